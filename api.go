@@ -13,6 +13,8 @@ import (
 type API struct {
 	settingEngine *SettingEngine
 	mediaEngine   *MediaEngine
+
+	receiveMemory *receiveMemoryPool
 }
 
 // NewAPI Creates a new API object for keeping semi-global settings to WebRTC objects
@@ -35,6 +37,10 @@ func NewAPI(options ...func(*API)) *API {
 		a.mediaEngine = &MediaEngine{}
 	}
 
+	if a.settingEngine.receiveMemoryLimit > 0 {
+		a.receiveMemory = newReceiveMemoryPool(a.settingEngine.receiveMemoryLimit)
+	}
+
 	return a
 }
 
@@ -10,6 +10,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -37,7 +38,8 @@ type DTLSTransport struct {
 	state                 DTLSTransportState
 	srtpProtectionProfile srtp.ProtectionProfile
 
-	onStateChangeHandler func(DTLSTransportState)
+	onStateChangeHandler  func(DTLSTransportState)
+	onDecryptErrorHandler func(ssrc uint32, err error)
 
 	conn *dtls.Conn
 
@@ -110,6 +112,35 @@ func (t *DTLSTransport) OnStateChange(f func(DTLSTransportState)) {
 	t.onStateChangeHandler = f
 }
 
+// OnDecryptError sets a handler that is fired when a received SRTP or SRTCP
+// packet is dropped, because it failed authentication or replay protection.
+// ssrc is the SSRC the packet claims to be from, the error wraps
+// ErrSRTPDecryptFailed. The handler is called from the goroutine reading the
+// transport and shouldn't block.
+//
+// Reporting the SSRC requires authenticating every received packet an extra
+// time, so it is only done if a handler is set before SRTP starts, when the
+// DTLS handshake completes. SRTCP packets that aren't encrypted are rejected
+// and reported then, otherwise they are passed on without their tag being
+// checked. With the AEAD_AES_128_GCM profile, the default, the tag of encrypted
+// SRTCP packets isn't reliably checked either, so a forged SRTCP packet may be
+// delivered without being reported. SRTP packets are always authenticated.
+func (t *DTLSTransport) OnDecryptError(f func(ssrc uint32, err error)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.onDecryptErrorHandler = f
+}
+
+func (t *DTLSTransport) onDecryptError(ssrc uint32, err error) {
+	t.lock.RLock()
+	handler := t.onDecryptErrorHandler
+	t.lock.RUnlock()
+
+	if handler != nil {
+		handler(ssrc, fmt.Errorf("%w: %v", ErrSRTPDecryptFailed, err))
+	}
+}
+
 // State returns the current dtls transport state.
 func (t *DTLSTransport) State() DTLSTransportState {
 	t.lock.RLock()
@@ -192,12 +223,24 @@ func (t *DTLSTransport) startSRTP() error {
 		return fmt.Errorf("%w: %v", errDtlsKeyExtractionFailed, err)
 	}
 
-	srtpSession, err := srtp.NewSessionSRTP(t.srtpEndpoint, srtpConfig)
+	// Rejected packets are only reported if someone is listening, the sessions
+	// read the endpoints directly otherwise
+	var srtpConn, srtcpConn net.Conn = t.srtpEndpoint, t.srtcpEndpoint
+	if t.onDecryptErrorHandler != nil {
+		if srtpConn, err = t.newSRTPAuthenticator(srtpConfig, t.srtpEndpoint, false); err != nil {
+			return fmt.Errorf("%w: %v", errFailedToStartSRTP, err)
+		}
+		if srtcpConn, err = t.newSRTPAuthenticator(srtpConfig, t.srtcpEndpoint, true); err != nil {
+			return fmt.Errorf("%w: %v", errFailedToStartSRTCP, err)
+		}
+	}
+
+	srtpSession, err := srtp.NewSessionSRTP(srtpConn, srtpConfig)
 	if err != nil {
 		return fmt.Errorf("%w: %v", errFailedToStartSRTP, err)
 	}
 
-	srtcpSession, err := srtp.NewSessionSRTCP(t.srtcpEndpoint, srtpConfig)
+	srtcpSession, err := srtp.NewSessionSRTCP(srtcpConn, srtpConfig)
 	if err != nil {
 		return fmt.Errorf("%w: %v", errFailedToStartSRTCP, err)
 	}
@@ -207,6 +250,29 @@ func (t *DTLSTransport) startSRTP() error {
 	return nil
 }
 
+// newSRTPAuthenticator wraps an endpoint to report the packets read from it that
+// fail authentication, with a remote context configured like the sessions' own
+func (t *DTLSTransport) newSRTPAuthenticator(srtpConfig *srtp.Config, endpoint net.Conn, rtcp bool) (*srtpAuthenticator, error) {
+	// Replay protection is enabled with the same defaults as the sessions use
+	options := append([]srtp.ContextOption{
+		srtp.SRTPReplayProtection(srtpReplayProtectionWindow),
+		srtp.SRTCPReplayProtection(srtpReplayProtectionWindow),
+	}, srtpConfig.RemoteOptions...)
+
+	context, err := srtp.CreateContext(srtpConfig.Keys.RemoteMasterKey, srtpConfig.Keys.RemoteMasterSalt, srtpConfig.Profile, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &srtpAuthenticator{
+		Conn:       endpoint,
+		context:    context,
+		profile:    srtpConfig.Profile,
+		rtcp:       rtcp,
+		onRejected: t.onDecryptError,
+	}, nil
+}
+
 func (t *DTLSTransport) getSRTPSession() (*srtp.SessionSRTP, error) {
 	value := t.srtpSession.Load()
 	if value != nil {
@@ -304,6 +370,17 @@ func (t *DTLSTransport) Start(remoteParameters DTLSParameters) error {
 		dtlsConfig.ReplayProtectionWindow = int(*t.api.settingEngine.replayProtection.DTLS)
 	}
 
+	if profiles := t.api.settingEngine.srtpProtectionProfiles; len(profiles) != 0 {
+		dtlsConfig.SRTPProtectionProfiles = make([]dtls.SRTPProtectionProfile, len(profiles))
+		for i, profile := range profiles {
+			dtlsConfig.SRTPProtectionProfiles[i] = dtls.SRTPProtectionProfile(profile)
+		}
+	}
+
+	if t.api.settingEngine.timeout.DTLSRetransmitInterval != nil {
+		dtlsConfig.FlightInterval = *t.api.settingEngine.timeout.DTLSRetransmitInterval
+	}
+
 	// Connect as DTLS Client/Server, function is blocking and we
 	// must not hold the DTLSTransport lock
 	if role == DTLSRoleClient {
@@ -5,10 +5,13 @@ package webrtc
 import (
 	"context"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pion/logging"
 	"github.com/pion/transport/test"
+	"github.com/pion/transport/vnet"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -134,3 +137,144 @@ func TestPeerConnection_DTLSRoleSettingEngine(t *testing.T) {
 		runTest(DTLSRoleClient)
 	})
 }
+
+// SetDTLSRetransmitInterval MUST control how often lost handshake flights are retransmitted
+func TestSetDTLSRetransmitInterval(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const (
+		retransmitInterval = 100 * time.Millisecond
+		droppedFlights     = 4
+	)
+
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	assert.NoError(t, err)
+
+	// Drop the first ClientHello flights, and record when each was sent
+	var clientHellosLock sync.Mutex
+	clientHellos := []time.Time{}
+	wan.AddChunkFilter(func(c vnet.Chunk) bool {
+		b := c.UserData()
+		if len(b) <= 13 || b[0] != 22 || b[13] != 1 {
+			return true
+		}
+
+		clientHellosLock.Lock()
+		defer clientHellosLock.Unlock()
+		clientHellos = append(clientHellos, time.Now())
+		return len(clientHellos) > droppedFlights
+	})
+
+	newAPI := func(ip string) *API {
+		n := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+		assert.NoError(t, wan.AddNet(n))
+
+		s := SettingEngine{}
+		s.SetVNet(n)
+		s.SetDTLSRetransmitInterval(retransmitInterval)
+		return NewAPI(WithSettingEngine(s))
+	}
+	offerAPI, answerAPI := newAPI("1.2.3.4"), newAPI("1.2.3.5")
+	assert.NoError(t, wan.Start())
+
+	pcOffer, err := offerAPI.NewPeerConnection(Configuration{})
+	assert.NoError(t, err)
+	pcAnswer, err := answerAPI.NewPeerConnection(Configuration{})
+	assert.NoError(t, err)
+
+	connected, connectedFunc := context.WithCancel(context.Background())
+	pcAnswer.OnConnectionStateChange(func(connectionState PeerConnectionState) {
+		if connectionState == PeerConnectionStateConnected {
+			connectedFunc()
+		}
+	})
+
+	assert.NoError(t, signalPair(pcOffer, pcAnswer))
+	<-connected.Done()
+
+	clientHellosLock.Lock()
+	assert.Greater(t, len(clientHellos), droppedFlights)
+	for i := 1; i <= droppedFlights; i++ {
+		interval := clientHellos[i].Sub(clientHellos[i-1])
+		assert.GreaterOrEqual(t, int64(interval), int64(retransmitInterval/2))
+		assert.Less(t, int64(interval), int64(5*retransmitInterval))
+	}
+	clientHellosLock.Unlock()
+
+	closePairNow(t, pcOffer, pcAnswer)
+	assert.NoError(t, wan.Stop())
+}
+
+func TestSetSRTPProtectionProfiles(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, testCase := range []struct {
+		name            string
+		offerProfiles   []SRTPProtectionProfile
+		answerProfiles  []SRTPProtectionProfile
+		expectedProfile SRTPProtectionProfile
+	}{
+		{"Default", nil, nil, SRTPProtectionProfileAeadAes128Gcm},
+		{
+			"Client Order",
+			[]SRTPProtectionProfile{SRTPProtectionProfileAeadAes128Gcm, SRTPProtectionProfileAes128CmHmacSha1_80},
+			[]SRTPProtectionProfile{SRTPProtectionProfileAes128CmHmacSha1_80, SRTPProtectionProfileAeadAes128Gcm},
+			SRTPProtectionProfileAes128CmHmacSha1_80,
+		},
+		{
+			"Restricted Server",
+			[]SRTPProtectionProfile{SRTPProtectionProfileAes128CmHmacSha1_80},
+			nil,
+			SRTPProtectionProfileAes128CmHmacSha1_80,
+		},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			newPeerConnection := func(profiles []SRTPProtectionProfile) *PeerConnection {
+				s := SettingEngine{}
+				if profiles != nil {
+					s.SetSRTPProtectionProfiles(profiles)
+				}
+
+				pc, err := NewAPI(WithSettingEngine(s)).NewPeerConnection(Configuration{})
+				assert.NoError(t, err)
+				return pc
+			}
+			// The answerer is the DTLS client
+			pcOffer, pcAnswer := newPeerConnection(testCase.offerProfiles), newPeerConnection(testCase.answerProfiles)
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			for _, pc := range []*PeerConnection{pcOffer, pcAnswer} {
+				var once sync.Once
+				pc.OnConnectionStateChange(func(connectionState PeerConnectionState) {
+					if connectionState == PeerConnectionStateConnected {
+						once.Do(wg.Done)
+					}
+				})
+			}
+
+			assert.NoError(t, signalPair(pcOffer, pcAnswer))
+			wg.Wait()
+
+			for _, pc := range []*PeerConnection{pcOffer, pcAnswer} {
+				pc.dtlsTransport.lock.RLock()
+				assert.Equal(t, testCase.expectedProfile, SRTPProtectionProfile(pc.dtlsTransport.srtpProtectionProfile))
+				pc.dtlsTransport.lock.RUnlock()
+			}
+
+			closePairNow(t, pcOffer, pcAnswer)
+		})
+	}
+}
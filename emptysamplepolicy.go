@@ -0,0 +1,42 @@
+package webrtc
+
+// EmptySamplePolicy determines how Track.WriteSample handles a media.Sample
+// that carries no Data.
+type EmptySamplePolicy int
+
+const (
+	// EmptySamplePolicySkip indicates that empty samples are silently
+	// dropped. No packets are sent and the RTP timestamp is not advanced.
+	// This is the default.
+	EmptySamplePolicySkip EmptySamplePolicy = iota + 1
+
+	// EmptySamplePolicyError indicates that WriteSample returns
+	// ErrEmptySample when given an empty sample.
+	EmptySamplePolicyError
+
+	// EmptySamplePolicyAdvance indicates that no packets are sent, but the
+	// RTP timestamp is advanced by the Samples of the empty sample. This is
+	// useful for codecs using discontinuous transmission (DTX), where the
+	// receiver must see the gap in the timeline.
+	EmptySamplePolicyAdvance
+)
+
+// This is done this way because of a linter.
+const (
+	emptySamplePolicySkipStr    = "skip"
+	emptySamplePolicyErrorStr   = "error"
+	emptySamplePolicyAdvanceStr = "advance"
+)
+
+func (p EmptySamplePolicy) String() string {
+	switch p {
+	case EmptySamplePolicySkip:
+		return emptySamplePolicySkipStr
+	case EmptySamplePolicyError:
+		return emptySamplePolicyErrorStr
+	case EmptySamplePolicyAdvance:
+		return emptySamplePolicyAdvanceStr
+	default:
+		return ErrUnknownType.Error()
+	}
+}
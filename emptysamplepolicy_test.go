@@ -0,0 +1,27 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmptySamplePolicy_String(t *testing.T) {
+	testCases := []struct {
+		policy         EmptySamplePolicy
+		expectedString string
+	}{
+		{EmptySamplePolicy(Unknown), unknownStr},
+		{EmptySamplePolicySkip, "skip"},
+		{EmptySamplePolicyError, "error"},
+		{EmptySamplePolicyAdvance, "advance"},
+	}
+
+	for i, testCase := range testCases {
+		assert.Equal(t,
+			testCase.expectedString,
+			testCase.policy.String(),
+			"testCase: %d %v", i, testCase,
+		)
+	}
+}
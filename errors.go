@@ -129,12 +129,29 @@ var (
 	// ErrFailedToGenerateCertificateFingerprint indicates that we failed to generate the fingerprint used for comparing certificates
 	ErrFailedToGenerateCertificateFingerprint = errors.New("failed to generate certificate fingerprint")
 
+	// ErrEmptySample indicates that WriteSample was called with a sample that has no Data
+	// while the Track is configured with EmptySamplePolicyError
+	ErrEmptySample = errors.New("sample contains no data")
+
+	// ErrTooManyPackets indicates that WriteSample was called with a sample that would be
+	// packetized into more packets than allowed by SetMaxPacketsPerSample
+	ErrTooManyPackets = errors.New("sample exceeds the maximum packets per sample")
+
+	// ErrSRTPDecryptFailed indicates that a received SRTP or SRTCP packet was dropped
+	// because it failed authentication, replay protection or could not be decrypted
+	ErrSRTPDecryptFailed = errors.New("failed to decrypt SRTP packet")
+
+	// ErrTrackReceiveStateExported indicates that Read was called on a remote Track
+	// whose receive state was moved out of it with ExportReceiveState
+	ErrTrackReceiveStateExported = errors.New("receive state of the track has been exported")
+
 	errDetachNotEnabled                 = errors.New("enable detaching by calling webrtc.DetachDataChannels()")
 	errDetachBeforeOpened               = errors.New("datachannel not opened yet, try calling Detach from OnOpen")
 	errDtlsTransportNotStarted          = errors.New("the DTLS transport has not started yet")
 	errDtlsKeyExtractionFailed          = errors.New("failed extracting keys from DTLS for SRTP")
 	errFailedToStartSRTP                = errors.New("failed to start SRTP")
 	errFailedToStartSRTCP               = errors.New("failed to start SRTCP")
+	errSRTCPUnencrypted                 = errors.New("SRTCP packet is not encrypted")
 	errInvalidDTLSStart                 = errors.New("attempted to start DTLSTransport that is not in new state")
 	errNoRemoteCertificate              = errors.New("peer didn't provide certificate via DTLS")
 	errIdentityProviderNotImplemented   = errors.New("identity provider is not implemented")
@@ -208,4 +225,15 @@ var (
 	errTrackLocalTrackRead   = errors.New("this is a local track and must not be read from")
 	errTrackLocalTrackWrite  = errors.New("this is a remote track and must not be written to")
 	errTrackSSRCNewTrackZero = errors.New("SSRC supplied to NewTrack() must be non-zero")
+
+	errTrackInitialTimestampAfterWrite = errors.New("initial timestamp must be set before the first sample is written")
+	errTrackSpatialLayerSSRCInvalid    = errors.New("spatial layer SSRCs must be non-zero and differ from each other and the track SSRC")
+	errTrackSpatialLayerUnknown        = errors.New("sample written for a spatial layer without SSRC")
+	errTrackReadAheadStopped           = errors.New("track stopped reading ahead")
+
+	errTrackLocalTrackReceiveState     = errors.New("this is a local track and has no receive state")
+	errTrackReceiveStateTooShort       = errors.New("track receive state is too short")
+	errTrackReceiveStateVersion        = errors.New("unsupported track receive state version")
+	errTrackReceiveStatePacketTooLarge = errors.New("buffered packet is too large for track receive state")
+	errTrackReceiveStateTooManyPackets = errors.New("too many buffered packets for track receive state")
 )
@@ -61,10 +61,15 @@ type PeerConnection struct {
 
 	rtpTransceivers []*RTPTransceiver
 
+	// remote tracks waiting to be delivered to onTracksHandler
+	pendingTrackEvents    []TrackEvent
+	deliveringTrackEvents bool
+
 	onSignalingStateChangeHandler     func(SignalingState)
 	onICEConnectionStateChangeHandler func(ICEConnectionState)
 	onConnectionStateChangeHandler    func(PeerConnectionState)
 	onTrackHandler                    func(*Track, *RTPReceiver)
+	onTracksHandler                   func([]TrackEvent)
 	onDataChannelHandler              func(*DataChannel)
 	onNegotiationNeededHandler        func()
 
@@ -412,18 +417,72 @@ func (pc *PeerConnection) OnTrack(f func(*Track, *RTPReceiver)) {
 	pc.onTrackHandler = f
 }
 
+// TrackEvent is a remote Track delivered by OnTracks, and the RTPReceiver it arrived on
+type TrackEvent struct {
+	Track    *Track
+	Receiver *RTPReceiver
+}
+
+// OnTracks sets an event handler which is called when remote tracks
+// arrive from a remote peer. It is an alternative to OnTrack for sessions
+// where many tracks may arrive at once, for example when joining a large
+// conference.
+//
+// OnTrack starts a goroutine for every track. OnTracks instead runs a single
+// handler at a time, and tracks that arrive while it is running are coalesced
+// and delivered together in the next call. A slow handler never blocks the
+// PeerConnection, it only receives larger batches.
+func (pc *PeerConnection) OnTracks(f func([]TrackEvent)) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.onTracksHandler = f
+}
+
 func (pc *PeerConnection) onTrack(t *Track, r *RTPReceiver) {
-	pc.mu.RLock()
+	pc.mu.Lock()
 	handler := pc.onTrackHandler
-	pc.mu.RUnlock()
+	batchHandler := pc.onTracksHandler
+	startDelivering := false
+	if t != nil && batchHandler != nil {
+		pc.pendingTrackEvents = append(pc.pendingTrackEvents, TrackEvent{Track: t, Receiver: r})
+		startDelivering = !pc.deliveringTrackEvents
+		pc.deliveringTrackEvents = true
+	}
+	pc.mu.Unlock()
 
 	pc.log.Debugf("got new track: %+v", t)
 	if t != nil {
+		if pc.api != nil && pc.api.receiveMemory != nil {
+			t.startReadAhead()
+		}
+
 		if handler != nil {
 			go handler(t, r)
-		} else {
+		} else if batchHandler == nil {
 			pc.log.Warnf("OnTrack unset, unable to handle incoming media streams")
 		}
+
+		if startDelivering {
+			go pc.deliverTrackEvents()
+		}
+	}
+}
+
+// deliverTrackEvents calls onTracksHandler until no more tracks are pending
+func (pc *PeerConnection) deliverTrackEvents() {
+	for {
+		pc.mu.Lock()
+		events := pc.pendingTrackEvents
+		handler := pc.onTracksHandler
+		pc.pendingTrackEvents = nil
+		if len(events) == 0 || handler == nil {
+			pc.deliveringTrackEvents = false
+			pc.mu.Unlock()
+			return
+		}
+		pc.mu.Unlock()
+
+		handler(events)
 	}
 }
 
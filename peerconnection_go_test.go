@@ -15,6 +15,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1048,3 +1049,47 @@ func TestPeerConnection_MassiveTracks(t *testing.T) {
 	assert.NoError(t, offerPC.Close())
 	assert.NoError(t, answerPC.Close())
 }
+
+// Assert that a slow OnTracks handler doesn't block the PeerConnection
+// when many tracks arrive at once, and that every track is delivered
+func TestPeerConnection_OnTracks(t *testing.T) {
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
+	pc, err := NewPeerConnection(Configuration{})
+	assert.NoError(t, err)
+
+	const trackCount = 100
+	var (
+		release   = make(chan struct{})
+		delivered = make(chan []TrackEvent, trackCount)
+		running   int32
+	)
+	pc.OnTracks(func(events []TrackEvent) {
+		assert.Equal(t, int32(1), atomic.AddInt32(&running, 1), "OnTracks handler called concurrently")
+		<-release
+		delivered <- events
+		atomic.AddInt32(&running, -1)
+	})
+
+	// The handler is blocked for the whole loop
+	for i := 0; i < trackCount; i++ {
+		pc.onTrack(&Track{id: fmt.Sprintf("track-%d", i)}, nil)
+	}
+	close(release)
+
+	received := []string{}
+	batches := 0
+	for len(received) < trackCount {
+		for _, event := range <-delivered {
+			received = append(received, event.Track.ID())
+		}
+		batches++
+	}
+
+	for i, id := range received {
+		assert.Equal(t, fmt.Sprintf("track-%d", i), id)
+	}
+	assert.Less(t, batches, trackCount, "OnTracks events were not coalesced")
+	assert.NoError(t, pc.Close())
+}
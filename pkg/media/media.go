@@ -11,6 +11,10 @@ import (
 type Sample struct {
 	Data    []byte
 	Samples uint32
+
+	// SpatialLayer is the SVC spatial layer Data belongs to, when each spatial
+	// layer is sent with its own SSRC. Zero is the base layer
+	SpatialLayer uint8
 }
 
 // NSamples calculates the number of samples in media of length d with sampling frequency f.
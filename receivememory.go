@@ -0,0 +1,122 @@
+// +build !js
+
+package webrtc
+
+import (
+	"sync"
+)
+
+// receiveMemoryPool accounts the packets read ahead by the remote Tracks of an
+// API against the limit set with SettingEngine.SetReceiveMemoryLimit, and
+// evicts packets by DropPriority when it is hit.
+//
+// Lock order is pool, then readAheadQueue, then Track. The queues must not be
+// locked while calling into the pool.
+type receiveMemoryPool struct {
+	mu sync.Mutex
+
+	limit  int
+	used   int
+	queues map[*readAheadQueue]*receiveMemoryQueue
+}
+
+// receiveMemoryQueue is the state of a readAheadQueue in the pool
+type receiveMemoryQueue struct {
+	track *Track
+	bytes int
+}
+
+func newReceiveMemoryPool(limit int) *receiveMemoryPool {
+	return &receiveMemoryPool{
+		limit:  limit,
+		queues: map[*readAheadQueue]*receiveMemoryQueue{},
+	}
+}
+
+// reserve accounts n bytes about to be queued in q, evicting the oldest queued
+// packets of the Tracks with the lowest DropPriority until they fit. It returns
+// false if the packet has to be dropped instead, because every other Track with
+// queued packets has a higher priority than the Track of q, and q is empty.
+func (p *receiveMemoryPool) reserve(t *Track, q *readAheadQueue, n int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.queues[q]
+	if !ok {
+		entry = &receiveMemoryQueue{track: t}
+		p.queues[q] = entry
+	}
+
+	for p.used+n > p.limit {
+		victim := p.victim(q)
+
+		evicted := 0
+		victim.mu.Lock()
+		if len(victim.packets) != 0 {
+			data, _ := victim.pop()
+			evicted = len(data)
+			victim.evicted++
+		}
+		victim.mu.Unlock()
+
+		// Nothing could be evicted, drop the packet. The victim may also have just
+		// been read, with its bytes not released yet
+		if evicted == 0 {
+			q.mu.Lock()
+			q.evicted++
+			q.mu.Unlock()
+			return false
+		}
+
+		p.queues[victim].bytes -= evicted
+		p.used -= evicted
+	}
+
+	entry.bytes += n
+	p.used += n
+	return true
+}
+
+// victim returns the queue to evict a packet from to make room for one of q.
+// It is the queue with queued packets whose Track has the lowest DropPriority.
+// q itself wins ties, so a Track never evicts one of the same priority.
+func (p *receiveMemoryPool) victim(q *readAheadQueue) *readAheadQueue {
+	victim, victimPriority := q, p.queues[q].track.DropPriority()
+	for queue, entry := range p.queues {
+		if queue == q || entry.bytes == 0 {
+			continue
+		}
+
+		priority := entry.track.DropPriority()
+		if priority < victimPriority || (priority == victimPriority && victim != q && entry.bytes > p.queues[victim].bytes) {
+			victim, victimPriority = queue, priority
+		}
+	}
+	return victim
+}
+
+// release returns n bytes that were dequeued from q
+func (p *receiveMemoryPool) release(q *readAheadQueue, n int) {
+	if n == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry, ok := p.queues[q]; ok {
+		entry.bytes -= n
+		p.used -= n
+	}
+}
+
+// remove stops accounting q, once its Track is closed
+func (p *receiveMemoryPool) remove(q *readAheadQueue) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry, ok := p.queues[q]; ok {
+		p.used -= entry.bytes
+		delete(p.queues, q)
+	}
+}
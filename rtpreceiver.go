@@ -29,6 +29,9 @@ type RTPReceiver struct {
 	closed, received chan interface{}
 	mu               sync.RWMutex
 
+	// transceiver is the RTPTransceiver this receiver belongs to, if any
+	transceiver *RTPTransceiver
+
 	// A reference to the associated api object
 	api *API
 }
@@ -57,6 +60,12 @@ func (r *RTPReceiver) Transport() *DTLSTransport {
 	return r.transport
 }
 
+// isRTCPMux returns true once the receiver is receiving. RTCP always shares the
+// DTLSTransport of RTP, there is no separate RTCP component
+func (r *RTPReceiver) isRTCPMux() bool {
+	return r.haveReceived() && r.Transport() != nil
+}
+
 // Track returns the RtpTransceiver track
 func (r *RTPReceiver) Track() *Track {
 	r.mu.RLock()
@@ -68,6 +77,18 @@ func (r *RTPReceiver) Track() *Track {
 	return r.tracks[0].track
 }
 
+func (r *RTPReceiver) setTransceiver(t *RTPTransceiver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transceiver = t
+}
+
+func (r *RTPReceiver) getTransceiver() *RTPTransceiver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.transceiver
+}
+
 // Tracks returns the RtpTransceiver tracks
 // A RTPReceiver to support Simulcast may now have multiple tracks
 func (r *RTPReceiver) Tracks() []*Track {
@@ -16,6 +16,12 @@ type RTPSender struct {
 	track          *Track
 	rtcpReadStream *srtp.ReadStreamSRTCP
 
+	// layerRTCPReadStreams read the RTCP sent for the spatial layers of the Track
+	// above the base layer. When there are any, all streams are read by goroutines
+	// that pass the packets to Read through rtcpPackets
+	layerRTCPReadStreams []*srtp.ReadStreamSRTCP
+	rtcpPackets          chan []byte
+
 	transport *DTLSTransport
 
 	// nolint:godox
@@ -24,6 +30,9 @@ type RTPSender struct {
 	// transceiver negotiation status
 	negotiated bool
 
+	// transceiver is the RTPTransceiver this sender belongs to, if any
+	transceiver *RTPTransceiver
+
 	// A reference to the associated api object
 	api *API
 
@@ -67,6 +76,12 @@ func (r *RTPSender) setNegotiated() {
 	r.negotiated = true
 }
 
+// isRTCPMux returns true once the sender is sending. RTCP always shares the
+// DTLSTransport of RTP, there is no separate RTCP component
+func (r *RTPSender) isRTCPMux() bool {
+	return r.hasSent() && r.Transport() != nil
+}
+
 // Transport returns the currently-configured *DTLSTransport or nil
 // if one has not yet been configured
 func (r *RTPSender) Transport() *DTLSTransport {
@@ -82,6 +97,18 @@ func (r *RTPSender) Track() *Track {
 	return r.track
 }
 
+func (r *RTPSender) setTransceiver(t *RTPTransceiver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transceiver = t
+}
+
+func (r *RTPSender) getTransceiver() *RTPTransceiver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.transceiver
+}
+
 func (r *RTPSender) setTrack(track *Track) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -107,6 +134,23 @@ func (r *RTPSender) Send(parameters RTPSendParameters) error {
 		return err
 	}
 
+	// SRTCP is routed by the SSRC it is sent for, each spatial layer sent with
+	// its own SSRC has a stream of its own
+	for _, ssrc := range r.track.spatialLayerSSRCs() {
+		layerStream, err := srtcpSession.OpenReadStream(ssrc)
+		if err != nil {
+			return err
+		}
+		r.layerRTCPReadStreams = append(r.layerRTCPReadStreams, layerStream)
+	}
+	if len(r.layerRTCPReadStreams) != 0 {
+		r.rtcpPackets = make(chan []byte)
+		go r.readRTCPStream(r.rtcpReadStream)
+		for _, layerStream := range r.layerRTCPReadStreams {
+			go r.readRTCPStream(layerStream)
+		}
+	}
+
 	r.track.mu.Lock()
 	r.track.activeSenders = append(r.track.activeSenders, r)
 	r.track.mu.Unlock()
@@ -132,28 +176,105 @@ func (r *RTPSender) Stop() error {
 	for _, s := range r.track.activeSenders {
 		if s != r {
 			filtered = append(filtered, s)
-		} else {
-			r.track.totalSenderCount--
 		}
 	}
 	r.track.activeSenders = filtered
+	r.track.totalSenderCount--
+	r.track.stoppedSenderCount++
 	close(r.stopCalled)
 
 	if r.hasSent() {
+		for _, layerStream := range r.layerRTCPReadStreams {
+			if err := layerStream.Close(); err != nil {
+				return err
+			}
+		}
 		return r.rtcpReadStream.Close()
 	}
 
 	return nil
 }
 
-// Read reads incoming RTCP for this RTPReceiver
+// readRTCPStream passes the packets read from one of the RTCP streams of the
+// RTPSender to Read, until the stream is closed
+func (r *RTPSender) readRTCPStream(stream *srtp.ReadStreamSRTCP) {
+	for {
+		b := make([]byte, receiveMTU)
+		n, err := stream.Read(b)
+		if err != nil {
+			return
+		}
+
+		select {
+		case r.rtcpPackets <- b[:n]:
+		case <-r.stopCalled:
+			return
+		}
+	}
+}
+
+// Read reads incoming RTCP for this RTPSender, including the RTCP sent for the
+// SSRCs of its spatial layers, see Track.SetSpatialLayerSSRCs
 func (r *RTPSender) Read(b []byte) (n int, err error) {
 	select {
 	case <-r.sendCalled:
-		return r.rtcpReadStream.Read(b)
 	case <-r.stopCalled:
 		return 0, io.ErrClosedPipe
 	}
+
+	if r.rtcpPackets == nil {
+		n, err = r.rtcpReadStream.Read(b)
+	} else {
+		select {
+		case pkt := <-r.rtcpPackets:
+			if len(b) < len(pkt) {
+				return 0, io.ErrShortBuffer
+			}
+			n = copy(b, pkt)
+		case <-r.stopCalled:
+			return 0, io.ErrClosedPipe
+		}
+	}
+
+	if err == nil {
+		r.handleRTCP(b[:n])
+	}
+	return n, err
+}
+
+// handleRTCP updates the Track with the feedback carried by RTCP read from this RTPSender
+func (r *RTPSender) handleRTCP(b []byte) {
+	pkts, err := rtcp.Unmarshal(b)
+	if err != nil {
+		return
+	}
+
+	// RemoveTrack unsets the Track once the RTPSender is stopped, while RTCP may
+	// still be handled
+	track := r.Track()
+	if track == nil {
+		return
+	}
+
+	// REMB applies to the Track if it is sent for its SSRC or any of its layers
+	trackSSRCs := append([]uint32{track.SSRC()}, track.spatialLayerSSRCs()...)
+
+	for _, pkt := range pkts {
+		remb, ok := pkt.(*rtcp.ReceiverEstimatedMaximumBitrate)
+		if !ok {
+			continue
+		}
+
+		appliesToTrack := len(remb.SSRCs) == 0
+		for _, ssrc := range remb.SSRCs {
+			for _, trackSSRC := range trackSSRCs {
+				appliesToTrack = appliesToTrack || ssrc == trackSSRC
+			}
+		}
+		if appliesToTrack {
+			track.SetBandwidthEstimate(int(remb.Bitrate))
+		}
+	}
 }
 
 // ReadRTCP is a convenience method that wraps Read and unmarshals for you
@@ -0,0 +1,21 @@
+// +build !js
+
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTPSender_HandleRTCPWithoutTrack(t *testing.T) {
+	remb, err := (&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 250000}).Marshal()
+	assert.NoError(t, err)
+
+	// The Track of a RTPSender is unset by RemoveTrack
+	sender := &RTPSender{}
+	assert.NotPanics(t, func() {
+		sender.handleRTCP(remb)
+	})
+}
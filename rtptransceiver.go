@@ -31,6 +31,9 @@ func (t *RTPTransceiver) Sender() *RTPSender {
 }
 
 func (t *RTPTransceiver) setSender(s *RTPSender) {
+	if s != nil {
+		s.setTransceiver(t)
+	}
 	t.sender.Store(s)
 }
 
@@ -88,6 +91,9 @@ func (t *RTPTransceiver) Stop() error {
 }
 
 func (t *RTPTransceiver) setReceiver(r *RTPReceiver) {
+	if r != nil {
+		r.setTransceiver(t)
+	}
 	t.receiver.Store(r)
 }
 
@@ -13,6 +13,10 @@ import (
 	"github.com/pion/sdp/v3"
 )
 
+// sdpSemanticTokenSimulcast groups the SSRCs of simulcast streams or
+// spatial layers, as used by browsers
+const sdpSemanticTokenSimulcast = "SIM"
+
 // trackDetails represents any media source that can be represented in a SDP
 // This isn't keyed by SSRC because it also needs to support rid based sources
 type trackDetails struct {
@@ -339,6 +343,14 @@ func addTransceiverSDP(d *sdp.SessionDescription, isPlanB bool, dtlsFingerprints
 		if mt.Sender() != nil && mt.Sender().Track() != nil {
 			track := mt.Sender().Track()
 			media = media.WithMediaSource(track.SSRC(), track.Label() /* cname */, track.Label() /* streamLabel */, track.ID())
+			if layerSSRCs := track.spatialLayerSSRCs(); len(layerSSRCs) != 0 {
+				group := []string{sdpSemanticTokenSimulcast, strconv.FormatUint(uint64(track.SSRC()), 10)}
+				for _, ssrc := range layerSSRCs {
+					media = media.WithMediaSource(ssrc, track.Label() /* cname */, track.Label() /* streamLabel */, track.ID())
+					group = append(group, strconv.FormatUint(uint64(ssrc), 10))
+				}
+				media = media.WithValueAttribute(sdp.AttrKeySSRCGroup, strings.Join(group, " "))
+			}
 			if !isPlanB {
 				media = media.WithPropertyAttribute("msid:" + track.Label() + " " + track.ID())
 				break
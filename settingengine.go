@@ -31,6 +31,7 @@ type SettingEngine struct {
 		ICESrflxAcceptanceMinWait *time.Duration
 		ICEPrflxAcceptanceMinWait *time.Duration
 		ICERelayAcceptanceMinWait *time.Duration
+		DTLSRetransmitInterval    *time.Duration
 	}
 	candidates struct {
 		ICELite                bool
@@ -54,10 +55,12 @@ type SettingEngine struct {
 	disableCertificateFingerprintVerification bool
 	disableSRTPReplayProtection               bool
 	disableSRTCPReplayProtection              bool
+	srtpProtectionProfiles                    []SRTPProtectionProfile
 	vnet                                      *vnet.Net
 	LoggerFactory                             logging.LoggerFactory
 	iceTCPMux                                 ice.TCPMux
 	iceProxyDialer                            proxy.Dialer
+	receiveMemoryLimit                        int
 }
 
 // DetachDataChannels enables detaching data channels. When enabled
@@ -97,6 +100,19 @@ func (e *SettingEngine) SetRelayAcceptanceMinWait(t time.Duration) {
 	e.timeout.ICERelayAcceptanceMinWait = &t
 }
 
+// SetDTLSRetransmitInterval sets how long the DTLS handshake waits for a
+// response before retransmitting its last flight. Default is 1 Second.
+// A Track can't be written to before the handshake completes, so on links with
+// packet loss shortening the interval reduces the time to the first packet.
+// A zero or negative interval restores the default.
+func (e *SettingEngine) SetDTLSRetransmitInterval(interval time.Duration) {
+	if interval <= 0 {
+		e.timeout.DTLSRetransmitInterval = nil
+		return
+	}
+	e.timeout.DTLSRetransmitInterval = &interval
+}
+
 // SetEphemeralUDPPortRange limits the pool of ephemeral ports that
 // ICE UDP connections can allocate from. This affects both host candidates,
 // and the local address of server reflexive candidates.
@@ -236,6 +252,16 @@ func (e *SettingEngine) DisableSRTCPReplayProtection(isDisabled bool) {
 	e.disableSRTCPReplayProtection = isDisabled
 }
 
+// SetSRTPProtectionProfiles sets the SRTP protection profiles offered in the
+// DTLS handshake, in order of preference. The DTLS client's order decides which
+// of the profiles supported by both sides is used. By default
+// SRTPProtectionProfileAeadAes128Gcm is preferred over
+// SRTPProtectionProfileAes128CmHmacSha1_80, leaving one of them out restricts
+// the handshake to the other.
+func (e *SettingEngine) SetSRTPProtectionProfiles(profiles []SRTPProtectionProfile) {
+	e.srtpProtectionProfiles = append([]SRTPProtectionProfile{}, profiles...)
+}
+
 // SetSDPMediaLevelFingerprints configures the logic for DTLS Fingerprint insertion
 // If true, fingerprints will be inserted in the sdp at the fingerprint
 // level, instead of the session level. This helps with compatibility with
@@ -316,3 +342,16 @@ func (e *SettingEngine) getSDPExtensions() map[SDPSectionType][]sdp.ExtMap {
 func (e *SettingEngine) SetICEProxyDialer(d proxy.Dialer) {
 	e.iceProxyDialer = d
 }
+
+// SetReceiveMemoryLimit caps how many bytes of received packets the remote
+// Tracks of all PeerConnections created with the API may hold before the
+// application reads them. When the limit is hit the oldest packets of the
+// Tracks with the lowest DropPriority are evicted first. Zero disables the
+// limit, which is the default.
+//
+// With a limit each remote Track reads its packets as soon as they are received
+// in a goroutine dedicated to it, as with Track.SetMaxLatency. Packets that are
+// still buffered by the SRTP session aren't accounted.
+func (e *SettingEngine) SetReceiveMemoryLimit(limit int) {
+	e.receiveMemoryLimit = limit
+}
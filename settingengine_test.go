@@ -48,6 +48,19 @@ func TestSetConnectionTimeout(t *testing.T) {
 	assert.Equal(t, *s.timeout.ICEKeepaliveInterval, 3*time.Second)
 }
 
+func TestSetDTLSRetransmitInterval_NonPositive(t *testing.T) {
+	s := SettingEngine{}
+
+	s.SetDTLSRetransmitInterval(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, *s.timeout.DTLSRetransmitInterval)
+
+	// Non-positive intervals restore the default instead of retransmitting in a tight loop
+	for _, interval := range []time.Duration{0, -time.Second} {
+		s.SetDTLSRetransmitInterval(interval)
+		assert.Nil(t, s.timeout.DTLSRetransmitInterval)
+	}
+}
+
 func TestDetachDataChannels(t *testing.T) {
 	s := SettingEngine{}
 
@@ -0,0 +1,269 @@
+// +build !js
+
+package webrtc
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+const (
+	// simulcastLayerStallTimeout is how long a simulcast layer may not receive
+	// packets before the SimulcastSwitcher falls back to another layer
+	simulcastLayerStallTimeout = time.Second
+
+	// simulcastKeyframeRequestInterval is how often a keyframe is requested from
+	// a layer the SimulcastSwitcher is waiting to switch to
+	simulcastKeyframeRequestInterval = 500 * time.Millisecond
+
+	h264NALUTypeIDR  = 5
+	h264NALUTypeSPS  = 7
+	h264NALUTypeSTAP = 24
+	h264NALUTypeFUA  = 28
+)
+
+// SimulcastSwitcher forwards a single layer of a received simulcast stream to a local
+// Track, for example in a SFU. The packets of every layer are passed to WriteRTP,
+// and only those of the active layer are written to the Track, rewritten so the
+// Track carries a single continuous stream.
+//
+// The preferred layer is forwarded while it receives packets. If it stalls, for
+// example because the sender dropped it, the switcher falls back to the next layer
+// that still receives packets, and switches back once the preferred layer recovers.
+// Switching only happens on a keyframe of the new layer, so the receiver can
+// decode across the switch without freezing. While a switch is pending the
+// OnKeyframeRequest handler is called, so a PLI can be sent for the new layer
+// instead of waiting for its next keyframe.
+type SimulcastSwitcher struct {
+	mu sync.Mutex
+
+	output *Track
+	rids   []string
+
+	preferred string
+	active    string
+	lastSeen  map[string]time.Time
+
+	started              bool
+	lastSequenceNumber   uint16
+	lastTimestamp        uint32
+	lastWrite            time.Time
+	sequenceNumberOffset uint16
+	timestampOffset      uint32
+
+	keyframeRequestLayer string
+	lastKeyframeRequest  time.Time
+
+	onLayerFallbackHandler   func(from, to string)
+	onKeyframeRequestHandler func(rid string)
+
+	write   func(*rtp.Packet) error
+	timegen func() time.Time
+}
+
+// NewSimulcastSwitcher creates a SimulcastSwitcher writing to output. rids are the
+// RIDs of the simulcast layers in the order they are fallen back to, the first
+// one is preferred. Starting from a stalled layer, the layers after it are tried
+// in order first, then the ones before it.
+func NewSimulcastSwitcher(output *Track, rids []string) *SimulcastSwitcher {
+	s := &SimulcastSwitcher{
+		output:   output,
+		rids:     append([]string{}, rids...),
+		lastSeen: map[string]time.Time{},
+		write:    output.WriteRTP,
+	}
+	if len(rids) != 0 {
+		s.preferred = rids[0]
+	}
+	return s
+}
+
+// SetPreferredLayer sets the RID of the layer to forward while it receives packets
+func (s *SimulcastSwitcher) SetPreferredLayer(rid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preferred = rid
+}
+
+// ActiveLayer returns the RID of the layer currently forwarded, or an empty
+// string before the first keyframe has been forwarded
+func (s *SimulcastSwitcher) ActiveLayer() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// OnLayerFallback sets an event handler which is called when the active layer
+// stalled, and the switcher fell back to forwarding another layer
+func (s *SimulcastSwitcher) OnLayerFallback(f func(from, to string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onLayerFallbackHandler = f
+}
+
+// OnKeyframeRequest sets an event handler which is called with the RID of the
+// layer the switcher waits to switch to, until a keyframe of it arrives. It is
+// called at most every 500ms, and usually sends a PLI for the SSRC of the layer.
+func (s *SimulcastSwitcher) OnKeyframeRequest(f func(rid string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onKeyframeRequestHandler = f
+}
+
+// WriteRTP passes a packet received on the layer with the given RID. The packet
+// is written to the output Track if the layer is active, or if it is a keyframe
+// of the layer the switcher is switching to.
+func (s *SimulcastSwitcher) WriteRTP(rid string, p *rtp.Packet) error {
+	s.mu.Lock()
+	now := s.now()
+	s.lastSeen[rid] = now
+
+	var fallbackHandler func(from, to string)
+	from := s.active
+	if rid != s.active {
+		if rid != s.target(now) {
+			s.mu.Unlock()
+			return nil
+		}
+		if !isKeyframe(s.output.Codec(), p) {
+			keyframeRequestHandler := s.keyframeRequest(rid, now)
+			s.mu.Unlock()
+			if keyframeRequestHandler != nil {
+				go keyframeRequestHandler(rid)
+			}
+			return nil
+		}
+
+		if from != "" && s.stalled(from, now) {
+			fallbackHandler = s.onLayerFallbackHandler
+		}
+		s.switchTo(rid, p, now)
+	}
+
+	forwarded := *p
+	forwarded.SSRC = s.output.SSRC()
+	forwarded.PayloadType = s.output.PayloadType()
+	forwarded.SequenceNumber += s.sequenceNumberOffset
+	forwarded.Timestamp += s.timestampOffset
+
+	s.started = true
+	s.lastSequenceNumber = forwarded.SequenceNumber
+	s.lastTimestamp = forwarded.Timestamp
+	s.lastWrite = now
+	s.mu.Unlock()
+
+	if fallbackHandler != nil {
+		go fallbackHandler(from, rid)
+	}
+	return s.write(&forwarded)
+}
+
+// keyframeRequest returns the OnKeyframeRequest handler if a keyframe of rid
+// should be requested now, or nil if one was requested recently
+func (s *SimulcastSwitcher) keyframeRequest(rid string, now time.Time) func(rid string) {
+	if s.keyframeRequestLayer == rid && now.Sub(s.lastKeyframeRequest) < simulcastKeyframeRequestInterval {
+		return nil
+	}
+
+	s.keyframeRequestLayer = rid
+	s.lastKeyframeRequest = now
+	return s.onKeyframeRequestHandler
+}
+
+// target returns the layer that should be active
+func (s *SimulcastSwitcher) target(now time.Time) string {
+	start := 0
+	for i, rid := range s.rids {
+		if rid == s.preferred {
+			start = i
+		}
+	}
+
+	for i := range s.rids {
+		if rid := s.rids[(start+i)%len(s.rids)]; !s.stalled(rid, now) {
+			return rid
+		}
+	}
+	return s.preferred
+}
+
+// stalled returns if a layer didn't receive packets for simulcastLayerStallTimeout
+func (s *SimulcastSwitcher) stalled(rid string, now time.Time) bool {
+	lastSeen, ok := s.lastSeen[rid]
+	return !ok || now.Sub(lastSeen) > simulcastLayerStallTimeout
+}
+
+// switchTo makes rid the active layer, starting with the keyframe p. The offsets
+// continue the sequence numbers of the output, and advance its timestamps by
+// the time elapsed since the last packet forwarded.
+func (s *SimulcastSwitcher) switchTo(rid string, p *rtp.Packet, now time.Time) {
+	s.active = rid
+	s.keyframeRequestLayer = ""
+	if !s.started {
+		return
+	}
+
+	elapsed := uint32(1)
+	if codec := s.output.Codec(); codec != nil {
+		if e := uint32(now.Sub(s.lastWrite) * time.Duration(codec.ClockRate) / time.Second); e > elapsed {
+			elapsed = e
+		}
+	}
+
+	s.sequenceNumberOffset = s.lastSequenceNumber + 1 - p.SequenceNumber
+	s.timestampOffset = s.lastTimestamp + elapsed - p.Timestamp
+}
+
+func (s *SimulcastSwitcher) now() time.Time {
+	if s.timegen != nil {
+		return s.timegen()
+	}
+	return time.Now()
+}
+
+// isKeyframe returns if p starts a keyframe. Keyframes are detected for VP8 and
+// H264, every packet is considered a keyframe for other codecs.
+func isKeyframe(codec *RTPCodec, p *rtp.Packet) bool {
+	if codec == nil {
+		return true
+	}
+
+	switch {
+	case strings.EqualFold(codec.Name, VP8):
+		vp8 := &codecs.VP8Packet{}
+		if _, err := vp8.Unmarshal(p.Payload); err != nil || len(vp8.Payload) == 0 {
+			return false
+		}
+		// The P bit of the VP8 payload header is zero for keyframes
+		return vp8.S == 1 && vp8.PID == 0 && vp8.Payload[0]&0x01 == 0
+	case strings.EqualFold(codec.Name, H264):
+		if len(p.Payload) < 2 {
+			return false
+		}
+
+		switch naluType := p.Payload[0] & 0x1F; naluType {
+		case h264NALUTypeSTAP:
+			// Aggregated NALUs, each prefixed by its size
+			for offset := 1; offset+2 < len(p.Payload); {
+				size := int(p.Payload[offset])<<8 | int(p.Payload[offset+1])
+				if t := p.Payload[offset+2] & 0x1F; t == h264NALUTypeIDR || t == h264NALUTypeSPS {
+					return true
+				}
+				offset += 2 + size
+			}
+			return false
+		case h264NALUTypeFUA:
+			// The start of a fragmented NALU
+			t := p.Payload[1] & 0x1F
+			return p.Payload[1]&0x80 != 0 && (t == h264NALUTypeIDR || t == h264NALUTypeSPS)
+		default:
+			return naluType == h264NALUTypeIDR || naluType == h264NALUTypeSPS
+		}
+	default:
+		return true
+	}
+}
@@ -0,0 +1,173 @@
+// +build !js
+
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulcastSwitcherLayerFallback(t *testing.T) {
+	output, err := NewTrack(DefaultPayloadTypeVP8, 5000, "video", "pion", NewRTPVP8Codec(DefaultPayloadTypeVP8, 90000))
+	assert.NoError(t, err)
+
+	switcher := NewSimulcastSwitcher(output, []string{"f", "h", "q"})
+
+	now := time.Unix(0, 0)
+	switcher.timegen = func() time.Time { return now }
+
+	forwarded := []*rtp.Packet{}
+	switcher.write = func(p *rtp.Packet) error {
+		forwarded = append(forwarded, p)
+		return nil
+	}
+
+	fallbacks := make(chan [2]string, 1)
+	switcher.OnLayerFallback(func(from, to string) {
+		fallbacks <- [2]string{from, to}
+	})
+
+	keyframeRequests := make(chan string, 100)
+	switcher.OnKeyframeRequest(func(rid string) {
+		keyframeRequests <- rid
+	})
+	// drainKeyframeRequests returns the RIDs keyframes were requested for since the last call
+	drainKeyframeRequests := func() (rids []string) {
+		for {
+			select {
+			case rid := <-keyframeRequests:
+				rids = append(rids, rid)
+			case <-time.After(50 * time.Millisecond):
+				return rids
+			}
+		}
+	}
+
+	// Each layer has its own sequence numbers and timestamps
+	sequenceNumbers := map[string]uint16{"f": 100, "h": 20000, "q": 65530}
+	timestamps := map[string]uint32{"f": 1000, "h": 500000, "q": 90000000}
+	write := func(rid string, keyframe bool) {
+		// VP8 payload descriptor starting a partition, followed by the P bit of the payload header
+		payload := []byte{0x10, 0x01, 0x00, byte(len(forwarded))}
+		if keyframe {
+			payload[1] = 0x00
+		}
+
+		assert.NoError(t, switcher.WriteRTP(rid, &rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 100, SequenceNumber: sequenceNumbers[rid], Timestamp: timestamps[rid], SSRC: 1},
+			Payload: payload,
+		}))
+		sequenceNumbers[rid]++
+		timestamps[rid] += 3000
+	}
+	// writeLayers writes a frame on each of the layers, and advances the time
+	writeLayers := func(keyframe bool, rids ...string) {
+		for _, rid := range rids {
+			write(rid, keyframe)
+		}
+		now = now.Add(33 * time.Millisecond)
+	}
+	assertForwarded := func(count int) {
+		assert.Len(t, forwarded, count)
+		for i, p := range forwarded {
+			assert.Equal(t, output.SSRC(), p.SSRC)
+			assert.Equal(t, output.PayloadType(), p.PayloadType)
+			if i > 0 {
+				assert.Equal(t, forwarded[i-1].SequenceNumber+1, p.SequenceNumber)
+				assert.Greater(t, p.Timestamp-forwarded[i-1].Timestamp, uint32(0))
+			}
+		}
+	}
+
+	// Nothing is forwarded until the first keyframe of the preferred layer
+	writeLayers(false, "f", "h", "q")
+	assert.Empty(t, forwarded)
+	assert.Equal(t, "", switcher.ActiveLayer())
+	assert.Equal(t, []string{"f"}, drainKeyframeRequests())
+
+	writeLayers(true, "f", "h", "q")
+	for i := 0; i < 5; i++ {
+		writeLayers(false, "f", "h", "q")
+	}
+	assert.Equal(t, "f", switcher.ActiveLayer())
+	assertForwarded(6)
+
+	// The preferred layer stops, fallback waits for a keyframe of the next layer
+	for elapsed := time.Duration(0); elapsed <= 2*simulcastLayerStallTimeout; elapsed += 33 * time.Millisecond {
+		writeLayers(false, "h", "q")
+	}
+	assert.Equal(t, "f", switcher.ActiveLayer())
+	assertForwarded(6)
+
+	// Keyframes of the fallback layer are requested while waiting, but not on every packet
+	requested := drainKeyframeRequests()
+	assert.NotEmpty(t, requested)
+	assert.LessOrEqual(t, len(requested), int(simulcastLayerStallTimeout/simulcastKeyframeRequestInterval)+1)
+	for _, rid := range requested {
+		assert.Equal(t, "h", rid)
+	}
+
+	select {
+	case <-fallbacks:
+		t.Fatal("OnLayerFallback fired before a keyframe")
+	default:
+	}
+
+	writeLayers(true, "h", "q")
+	assert.Equal(t, "h", switcher.ActiveLayer())
+	select {
+	case fallback := <-fallbacks:
+		assert.Equal(t, [2]string{"f", "h"}, fallback)
+	case <-time.After(time.Second):
+		t.Fatal("OnLayerFallback didn't fire")
+	}
+	assertForwarded(7)
+	assert.Equal(t, byte(0x00), forwarded[6].Payload[1], "fallback didn't happen on a keyframe")
+
+	writeLayers(false, "h", "q")
+	assertForwarded(8)
+	assert.Empty(t, drainKeyframeRequests(), "keyframe requested after the switch")
+
+	// The preferred layer resumes, and is switched back to on its next keyframe
+	writeLayers(false, "f", "h", "q")
+	assert.Equal(t, "h", switcher.ActiveLayer())
+	writeLayers(true, "f", "h", "q")
+	assert.Equal(t, "f", switcher.ActiveLayer())
+	assertForwarded(10)
+
+	select {
+	case fallback := <-fallbacks:
+		t.Fatalf("OnLayerFallback fired when switching back to the preferred layer: %v", fallback)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	vp8 := NewRTPVP8Codec(DefaultPayloadTypeVP8, 90000)
+	h264 := NewRTPH264Codec(DefaultPayloadTypeH264, 90000)
+
+	for _, testCase := range []struct {
+		name     string
+		codec    *RTPCodec
+		payload  []byte
+		keyframe bool
+	}{
+		{"VP8 Keyframe", vp8, []byte{0x10, 0x00, 0x00, 0x00}, true},
+		{"VP8 Delta", vp8, []byte{0x10, 0x01, 0x00, 0x00}, false},
+		{"VP8 Continuation", vp8, []byte{0x00, 0x00, 0x00, 0x00}, false},
+		{"VP8 Too Short", vp8, []byte{0x10, 0x00}, false},
+		{"H264 IDR", h264, []byte{0x65, 0x00}, true},
+		{"H264 SPS", h264, []byte{0x67, 0x00}, true},
+		{"H264 Non-IDR", h264, []byte{0x41, 0x00}, false},
+		{"H264 STAP-A With SPS", h264, []byte{0x78, 0x00, 0x01, 0x06, 0x00, 0x02, 0x67, 0x00}, true},
+		{"H264 STAP-A Without IDR", h264, []byte{0x78, 0x00, 0x01, 0x06, 0x00, 0x01, 0x41}, false},
+		{"H264 FU-A IDR Start", h264, []byte{0x7C, 0x85, 0x00}, true},
+		{"H264 FU-A IDR Middle", h264, []byte{0x7C, 0x05, 0x00}, false},
+		{"Opus", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000), []byte{0x00}, true},
+	} {
+		assert.Equal(t, testCase.keyframe, isKeyframe(testCase.codec, &rtp.Packet{Payload: testCase.payload}), testCase.name)
+	}
+}
@@ -0,0 +1,95 @@
+// +build !js
+
+package webrtc
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp"
+)
+
+const (
+	// srtpReplayProtectionWindow is the replay protection window the SRTP and SRTCP
+	// sessions use, unless configured with the SettingEngine
+	srtpReplayProtectionWindow = 64
+
+	// srtcpIndexSize is the size of the E flag and SRTCP index trailing a packet,
+	// and srtcpAuthTagSizeHmacSha1 the size of the tag that follows them with
+	// SRTP_AES128_CM_HMAC_SHA1_80. With AEAD_AES_128_GCM the tag comes before them
+	srtcpIndexSize           = 4
+	srtcpAuthTagSizeHmacSha1 = 10
+)
+
+// srtpAuthenticator sits between an endpoint and the SRTP or SRTCP session
+// reading it. Every packet is authenticated and replay checked with a copy of
+// the remote context before it is passed on to the session, so packets that are
+// rejected can be reported with their SSRC. The session only receives the
+// authentic packets, which pass its own checks again. This doubles the cost of
+// decrypting, so it is only used when the rejections are reported.
+//
+// Packets too malformed to carry a SSRC are passed on as is, the session drops
+// them without them being a decrypt failure. SRTCP packets without the E flag
+// are rejected, the SRTCP session passes them on without checking their tag.
+type srtpAuthenticator struct {
+	net.Conn
+
+	context *srtp.Context
+	profile srtp.ProtectionProfile
+	rtcp    bool
+	buffer  []byte
+
+	onRejected func(ssrc uint32, err error)
+}
+
+// Read returns the next packet read from the endpoint that passed authentication
+func (a *srtpAuthenticator) Read(b []byte) (int, error) {
+	for {
+		n, err := a.Conn.Read(b)
+		if err != nil {
+			return n, err
+		}
+
+		ssrc, ok, err := a.authenticate(b[:n])
+		if !ok || err == nil {
+			return n, nil
+		}
+		a.onRejected(ssrc, err)
+	}
+}
+
+// authenticate decrypts a copy of a packet in the authenticator's buffer, the
+// ciphers may write to the encrypted buffer they are given. ok is false if the
+// packet has no SSRC to attribute it to.
+func (a *srtpAuthenticator) authenticate(packet []byte) (ssrc uint32, ok bool, err error) {
+	a.buffer = append(a.buffer[:0], packet...)
+
+	if a.rtcp {
+		header := &rtcp.Header{}
+		if len(packet) < 8 || header.Unmarshal(packet) != nil {
+			return 0, false, nil
+		}
+
+		ssrc = binary.BigEndian.Uint32(packet[4:])
+		indexOffset := len(packet) - srtcpIndexSize
+		if a.profile != srtp.ProtectionProfileAeadAes128Gcm {
+			indexOffset -= srtcpAuthTagSizeHmacSha1
+		}
+		if indexOffset < 8 || packet[indexOffset]>>7 == 0 {
+			return ssrc, true, errSRTCPUnencrypted
+		}
+
+		_, err = a.context.DecryptRTCP(a.buffer, a.buffer, header)
+		return ssrc, true, err
+	}
+
+	header := &rtp.Header{}
+	if header.Unmarshal(packet) != nil {
+		return 0, false, nil
+	}
+
+	_, err = a.context.DecryptRTP(a.buffer, a.buffer, header)
+	return header.SSRC, true, err
+}
@@ -0,0 +1,120 @@
+// +build !js
+
+package webrtc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp"
+	"github.com/stretchr/testify/assert"
+)
+
+// packetConn returns the packets it holds from Read, and io.EOF once they are all read
+type packetConn struct {
+	net.Conn
+	packets [][]byte
+}
+
+func (c *packetConn) Read(b []byte) (int, error) {
+	if len(c.packets) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, c.packets[0])
+	c.packets = c.packets[1:]
+	return n, nil
+}
+
+func TestSRTPAuthenticator(t *testing.T) {
+	for _, profile := range []srtp.ProtectionProfile{srtp.ProtectionProfileAes128CmHmacSha1_80, srtp.ProtectionProfileAeadAes128Gcm} {
+		for _, isRTCP := range []bool{false, true} {
+			if isRTCP && profile == srtp.ProtectionProfileAeadAes128Gcm {
+				// The SRTCP context misreads the index of AEAD packets, and passes them
+				// on as unencrypted without authenticating them
+				continue
+			}
+
+			keyLen, saltLen := 16, 14
+			if profile == srtp.ProtectionProfileAeadAes128Gcm {
+				saltLen = 12
+			}
+			key, salt := bytes.Repeat([]byte{0x01}, keyLen), bytes.Repeat([]byte{0x02}, saltLen)
+			newContext := func() *srtp.Context {
+				context, err := srtp.CreateContext(key, salt, profile,
+					srtp.SRTPReplayProtection(srtpReplayProtectionWindow), srtp.SRTCPReplayProtection(srtpReplayProtectionWindow))
+				assert.NoError(t, err)
+				return context
+			}
+			local := newContext()
+
+			encrypt := func(sequenceNumber uint16) []byte {
+				if isRTCP {
+					packet, err := (&rtcp.PictureLossIndication{SenderSSRC: 5000, MediaSSRC: uint32(sequenceNumber)}).Marshal()
+					assert.NoError(t, err)
+					encrypted, err := local.EncryptRTCP(nil, packet, nil)
+					assert.NoError(t, err)
+					return encrypted
+				}
+
+				packet, err := (&rtp.Packet{
+					Header:  rtp.Header{Version: 2, SSRC: 5000, SequenceNumber: sequenceNumber},
+					Payload: []byte{0x00, 0x01, 0x02, 0x03},
+				}).Marshal()
+				assert.NoError(t, err)
+				encrypted, err := local.EncryptRTP(nil, packet, nil)
+				assert.NoError(t, err)
+				return encrypted
+			}
+
+			authentic := encrypt(1)
+			replayed := append([]byte{}, authentic...)
+			tampered := encrypt(2)
+			tampered[len(tampered)-1] ^= 0xFF
+			malformed := []byte{0x80, 0x00}
+			next := encrypt(3)
+			packets := [][]byte{authentic, replayed, tampered, malformed, next}
+			expectedRejected := 2
+
+			if isRTCP {
+				// The session doesn't check the tag of packets without the E flag
+				unencrypted, err := (&rtcp.PictureLossIndication{SenderSSRC: 5000, MediaSSRC: 4}).Marshal()
+				assert.NoError(t, err)
+				unencrypted = append(unencrypted, 0x00, 0x00, 0x00, 0x04)
+				unencrypted = append(unencrypted, make([]byte, srtcpAuthTagSizeHmacSha1)...)
+				packets = append(packets, unencrypted)
+				expectedRejected++
+			}
+
+			rejected := map[uint32]int{}
+			a := &srtpAuthenticator{
+				Conn:    &packetConn{packets: packets},
+				context: newContext(),
+				profile: profile,
+				rtcp:    isRTCP,
+				onRejected: func(ssrc uint32, err error) {
+					assert.Error(t, err)
+					rejected[ssrc]++
+				},
+			}
+
+			// Rejected packets are skipped, malformed ones are left to the session.
+			// The packets are passed on unmodified, to be decrypted by the session
+			b := make([]byte, receiveMTU)
+			for _, expected := range [][]byte{authentic, malformed, next} {
+				n, err := a.Read(b)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, b[:n])
+			}
+			_, err := a.Read(b)
+			assert.True(t, errors.Is(err, io.EOF))
+
+			assert.Equal(t, map[uint32]int{5000: expectedRejected}, rejected, "%s rtcp=%v", profile, isRTCP)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package webrtc
+
+// SRTPProtectionProfile indicates the protection profile of the SRTP and SRTCP
+// sessions, negotiated in the DTLS handshake.
+type SRTPProtectionProfile uint16
+
+const (
+	// SRTPProtectionProfileAes128CmHmacSha1_80 defines AES-128 in counter mode
+	// with a 80 bit HMAC-SHA1 authentication tag, as defined in RFC 5764.
+	SRTPProtectionProfileAes128CmHmacSha1_80 SRTPProtectionProfile = 0x0001 // nolint:golint,stylecheck
+
+	// SRTPProtectionProfileAeadAes128Gcm defines AES-128 in Galois/Counter
+	// mode, as defined in RFC 7714.
+	SRTPProtectionProfileAeadAes128Gcm SRTPProtectionProfile = 0x0007
+)
+
+func (p SRTPProtectionProfile) String() string {
+	switch p {
+	case SRTPProtectionProfileAes128CmHmacSha1_80:
+		return "SRTP_AES128_CM_HMAC_SHA1_80"
+	case SRTPProtectionProfileAeadAes128Gcm:
+		return "SRTP_AEAD_AES_128_GCM"
+	default:
+		return unknownStr
+	}
+}
@@ -0,0 +1,26 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSRTPProtectionProfile_String(t *testing.T) {
+	testCases := []struct {
+		profile        SRTPProtectionProfile
+		expectedString string
+	}{
+		{SRTPProtectionProfile(Unknown), unknownStr},
+		{SRTPProtectionProfileAes128CmHmacSha1_80, "SRTP_AES128_CM_HMAC_SHA1_80"},
+		{SRTPProtectionProfileAeadAes128Gcm, "SRTP_AEAD_AES_128_GCM"},
+	}
+
+	for i, testCase := range testCases {
+		assert.Equal(t,
+			testCase.expectedString,
+			testCase.profile.String(),
+			"testCase: %d %v", i, testCase,
+		)
+	}
+}
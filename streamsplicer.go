@@ -0,0 +1,92 @@
+// +build !js
+
+package webrtc
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// StreamSplicer splices segments of RTP packets, for example recorded clips,
+// into the stream sent by a local Track. Each segment has its own sequence
+// numbers and timestamp base, AppendSegment rebases them so the Track carries a
+// single continuous stream with monotonic timestamps, while the timing within
+// each segment is preserved.
+type StreamSplicer struct {
+	mu sync.Mutex
+
+	output        *Track
+	frameDuration uint32
+}
+
+// NewStreamSplicer creates a StreamSplicer writing to output
+func NewStreamSplicer(output *Track) *StreamSplicer {
+	return &StreamSplicer{output: output}
+}
+
+// AppendSegment writes the packets of a segment to the output Track. The
+// segment continues from the last packet sent by the Track, whether it was
+// spliced or written with WriteSample, and starts one frame after it. The frame
+// duration is the last timestamp increment seen in a segment. The packets are
+// written right away, pacing them is up to the caller, and they aren't modified.
+// Samples and probes of the Track are written before or after a segment, never
+// in between its packets.
+func (s *StreamSplicer) AppendSegment(packets []*rtp.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(packets) == 0 {
+		return nil
+	}
+
+	t := s.output
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	first := packets[0]
+	var (
+		sequenceNumberOffset uint16
+		timestampOffset      uint32
+	)
+	t.mu.RLock()
+	ssrc, payloadType := t.ssrc, t.payloadType
+	if t.sampleRewriter.started {
+		sequenceNumberOffset = t.sampleRewriter.nextSequenceNumber - first.SequenceNumber
+		timestampOffset = t.sampleRewriter.nextTimestamp - first.Timestamp
+	}
+	t.mu.RUnlock()
+
+	last := &rtp.Packet{Header: rtp.Header{
+		SequenceNumber: first.SequenceNumber + sequenceNumberOffset,
+		Timestamp:      first.Timestamp + timestampOffset,
+	}}
+	for _, p := range packets {
+		spliced := *p
+		spliced.SSRC, spliced.PayloadType = ssrc, payloadType
+		spliced.SequenceNumber += sequenceNumberOffset
+		spliced.Timestamp += timestampOffset
+
+		if err := t.WriteRTP(&spliced); err != nil {
+			return err
+		}
+
+		// The segment ends at its latest packet, which isn't the last one if packets are reordered
+		if int16(spliced.SequenceNumber-last.SequenceNumber) > 0 {
+			last.SequenceNumber = spliced.SequenceNumber
+		}
+		if elapsed := int32(spliced.Timestamp - last.Timestamp); elapsed > 0 {
+			s.frameDuration = uint32(elapsed)
+			last.Timestamp = spliced.Timestamp
+		}
+	}
+
+	frameDuration := s.frameDuration
+	if frameDuration == 0 {
+		frameDuration = 1
+	}
+
+	t.mu.Lock()
+	t.sampleRewriter.replayed(last, frameDuration)
+	t.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,76 @@
+// +build !js
+
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/transport/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamSplicerAppendSegment(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeVP8)
+
+	// segment returns packets with their own sequence numbers and timestamp base,
+	// spaced by the given timestamp increments
+	segment := func(sequenceNumber uint16, timestamp uint32, increments ...uint32) []*rtp.Packet {
+		packets := []*rtp.Packet{}
+		for _, increment := range append([]uint32{0}, increments...) {
+			timestamp += increment
+			packets = append(packets, &rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 100, SSRC: 1, SequenceNumber: sequenceNumber, Timestamp: timestamp},
+				Payload: []byte{0x10, 0x00, 0x00, 0x00},
+			})
+			sequenceNumber++
+		}
+		return packets
+	}
+
+	splicer := NewStreamSplicer(local)
+	first := segment(100, 1000, 3000, 3000, 3000)
+	second := segment(60000, 4294965000, 3000, 6000, 0, 3000)
+	assert.NoError(t, splicer.AppendSegment(first))
+	assert.NoError(t, splicer.AppendSegment(second))
+	assert.Equal(t, uint16(100), first[0].SequenceNumber, "segment was modified")
+
+	received := []*rtp.Packet{}
+	for range append(first, second...) {
+		received = append(received, readMediaRTP(t, remote))
+	}
+
+	for i, p := range received {
+		assert.Equal(t, local.SSRC(), p.SSRC)
+		assert.Equal(t, local.PayloadType(), p.PayloadType)
+		if i == 0 {
+			continue
+		}
+
+		assert.Equal(t, received[i-1].SequenceNumber+1, p.SequenceNumber)
+		assert.GreaterOrEqual(t, int32(p.Timestamp-received[i-1].Timestamp), int32(0), "timestamps aren't monotonic")
+	}
+
+	// Timing within each segment is preserved
+	for i := 1; i < len(first); i++ {
+		assert.Equal(t, first[i].Timestamp-first[i-1].Timestamp, received[i].Timestamp-received[i-1].Timestamp)
+	}
+	for i := 1; i < len(second); i++ {
+		j := len(first) + i
+		assert.Equal(t, second[i].Timestamp-second[i-1].Timestamp, received[j].Timestamp-received[j-1].Timestamp)
+	}
+
+	// The second segment starts one frame of the first one after its end
+	assert.Equal(t, uint32(3000), received[len(first)].Timestamp-received[len(first)-1].Timestamp)
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
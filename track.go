@@ -3,24 +3,130 @@
 package webrtc
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3/internal/util"
 	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/rtpdump"
 )
 
 const (
 	rtpOutboundMTU          = 1200
+	rtpHeaderSize           = 12
+	rtpMaxCSRC              = 15
 	trackDefaultIDLength    = 16
 	trackDefaultLabelLength = 16
+
+	// clockRateMismatchWindow is how much wall clock time is compared against the
+	// Samples written before deciding if they disagree with the codec clock rate
+	clockRateMismatchWindow = time.Second
+
+	// bandwidthProbePeriod is how often a cluster of probe packets is sent while
+	// bandwidth probing
+	bandwidthProbePeriod = time.Second
+
+	// bandwidthProbeInterval is how long the packets of a probe cluster are spread over
+	bandwidthProbeInterval = 50 * time.Millisecond
+
+	// bandwidthProbeGain is the rate probe clusters are sent at, in percent of
+	// the current bandwidth estimate
+	bandwidthProbeGain = 125
+
+	// bandwidthProbeMinBitrate is the lowest rate probe clusters are sent at, in bits per second
+	bandwidthProbeMinBitrate = 100000
+
+	// bandwidthProbePaddingSize is the amount of padding carried by each probe packet
+	bandwidthProbePaddingSize = 255
+
+	// packetizationLatencyWeight is the inverse of the weight of each WriteSample
+	// call in the PacketizationLatency moving average
+	packetizationLatencyWeight = 8
+
+	// oneWayMediaThreshold is how long media may flow in only one direction of
+	// a Track pair before OnOneWayMedia fires
+	oneWayMediaThreshold = 5 * time.Second
+
+	// oneWayMediaMinCheckInterval is the shortest interval one-way media is checked at
+	oneWayMediaMinCheckInterval = time.Millisecond
+
+	// clockRateMismatchTolerance is the allowed relative difference between the
+	// rate implied by the written Samples and the codec clock rate
+	clockRateMismatchTolerance = 0.05
+
+	// trackBitrateWindow is the window of wall clock time Bitrate is averaged over
+	trackBitrateWindow = time.Second
 )
 
+// clockRateMonitor tracks the Samples written to a Track over a window of wall clock time
+type clockRateMonitor struct {
+	windowStart time.Time
+	lastWrite   time.Time
+	samples     uint64
+	mismatched  bool
+}
+
+// bitrateMonitor tracks the bytes received on a Track over the last trackBitrateWindow
+type bitrateMonitor struct {
+	arrivals []time.Time
+	sizes    []int
+}
+
+// add records n bytes read at now
+func (m *bitrateMonitor) add(now time.Time, n int) {
+	m.expire(now)
+	m.arrivals = append(m.arrivals, now)
+	m.sizes = append(m.sizes, n)
+}
+
+// bitrate returns the bits per second read in the window ending at now
+func (m *bitrateMonitor) bitrate(now time.Time) float64 {
+	m.expire(now)
+
+	bytes := 0
+	for i, arrival := range m.arrivals {
+		if !arrival.After(now) {
+			bytes += m.sizes[i]
+		}
+	}
+	return float64(bytes*8) / trackBitrateWindow.Seconds()
+}
+
+// expire drops the reads that are outside of the window ending at now
+func (m *bitrateMonitor) expire(now time.Time) {
+	expired := 0
+	for expired < len(m.arrivals) && now.Sub(m.arrivals[expired]) >= trackBitrateWindow {
+		expired++
+	}
+	m.arrivals = m.arrivals[expired:]
+	m.sizes = m.sizes[expired:]
+}
+
 // Track represents a single media track
 type Track struct {
+	// authenticated counts the packets read from the SRTP session. It is
+	// accessed atomically, and first in the struct to be 64-bit aligned
+	authenticated uint64
+
+	// bitrateGroups counts the TrackGroups the Track belongs to, and
+	// receiveStateExported is set once the receive state has been exported,
+	// after which the Track no longer delivers packets. Both are accessed atomically
+	bitrateGroups        int32
+	receiveStateExported int32
+
 	mu sync.RWMutex
 
+	// writeMu is held while writing samples and probes, so the sequence
+	// numbers they are assigned are sent in order. It is locked before mu
+	writeMu sync.Mutex
+
 	id          string
 	payloadType uint8
 	kind        RTPCodecType
@@ -29,12 +135,50 @@ type Track struct {
 	codec       *RTPCodec
 	rid         string
 
-	packetizer rtp.Packetizer
+	packetizer        rtp.Packetizer
+	emptySamplePolicy EmptySamplePolicy
+	maxPackets        int
+	sampleRewriter    sampleRewriter
+	spatialLayers     []spatialLayer
+
+	packetizationLatency time.Duration
+
+	clockRate                  clockRateMonitor
+	onClockRateMismatchHandler func()
+	timegen                    func() time.Time
+
+	receiver           *RTPReceiver
+	activeSenders      []*RTPSender
+	totalSenderCount   int // count of all senders (accounts for senders that have not been started yet)
+	stoppedSenderCount int // count of senders that have been stopped
+	buffered           [][]byte
+	receiveState       receiveState
+	lastHeader         []byte
+	lastPadding        int
+	receiveBitrate     bitrateMonitor
+	maxLatency         time.Duration
+	readAhead          *readAheadQueue
+	dropPriority       int
+
+	lastActivity         time.Time
+	onOneWayMediaHandler func()
+	oneWayMediaStarted   bool
+	oneWayMediaThreshold time.Duration
 
-	receiver         *RTPReceiver
-	activeSenders    []*RTPSender
-	totalSenderCount int // count of all senders (accounts for senders that have not been started yet)
-	peeked           []byte
+	onRTPHandler func(*rtp.Packet)
+	onRTPStarted bool
+
+	bandwidthEstimate    int
+	bandwidthProbing     chan struct{}    // closed to stop probing, nil when not probing
+	bandwidthProbeTicks  <-chan time.Time // replaces the bandwidthProbePeriod ticker if set
+	csrcMapping          map[uint32]uint32
+	sendCounters         TrackSendCounters
+	encoderSource        func(targetBitrate int) (media.Sample, error)
+	encoderSourceStarted bool
+
+	// encoderSourceGeneration is incremented each time the source is set
+	encoderSourceGeneration     uint64
+	onEncoderSourceErrorHandler func(err error)
 }
 
 // ID gets the ID of the track
@@ -94,6 +238,30 @@ func (t *Track) Codec() *RTPCodec {
 	return t.codec
 }
 
+// RTCPMuxed returns true if RTCP for this track shares a transport with RTP
+// (rtcp-mux). Only RTCPMuxPolicyRequire is supported, so once the track is being
+// sent or received its RTCP is always on the DTLSTransport of its RTP, even if
+// the remote description doesn't signal rtcp-mux. For a local track this is
+// true only when every RTPSender of the track is sending. Before negotiation has
+// completed this returns false.
+func (t *Track) RTCPMuxed() bool {
+	t.mu.RLock()
+	receiver := t.receiver
+	senders := t.activeSenders
+	t.mu.RUnlock()
+
+	if receiver != nil {
+		return receiver.isRTCPMux()
+	}
+
+	for _, s := range senders {
+		if !s.isRTCPMux() {
+			return false
+		}
+	}
+	return len(senders) != 0
+}
+
 // Packetizer gets the Packetizer of the track
 func (t *Track) Packetizer() rtp.Packetizer {
 	t.mu.RLock()
@@ -101,22 +269,154 @@ func (t *Track) Packetizer() rtp.Packetizer {
 	return t.packetizer
 }
 
+// SetPacketizer replaces the Packetizer used by WriteSample. A new Packetizer
+// starts with its own sequence number and timestamp, so by default the sequence
+// numbers it generates are rebased to continue from the last packet sent. This
+// avoids a discontinuity that receivers would treat as loss. SetPacketizerContinuity
+// controls what is carried over.
+func (t *Track) SetPacketizer(packetizer rtp.Packetizer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.packetizer = packetizer
+	t.sampleRewriter.reset()
+}
+
+// SetPacketizerContinuity sets what SetPacketizer carries over to the new Packetizer
+// * sequenceNumber continues the sequence numbers from the last packet sent. Disable
+// this when a reset is desired. Default is true
+// * timestamp continues the timestamps from the last sample sent. Default is false
+func (t *Track) SetPacketizerContinuity(sequenceNumber, timestamp bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sampleRewriter.resetSequenceNumber = !sequenceNumber
+	t.sampleRewriter.continueTimestamp = timestamp
+}
+
+// SetInitialTimestamp sets the RTP timestamp of the first packet written with
+// WriteSample, instead of the random one chosen by the Packetizer. Combined
+// with AlignedInitialTimestamps this keeps Tracks with different clock rates in
+// sync from the start. It must be called before the first sample is written
+func (t *Track) SetInitialTimestamp(timestamp uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sampleRewriter.started {
+		return errTrackInitialTimestampAfterWrite
+	}
+
+	t.sampleRewriter.initialTimestamp = &timestamp
+	return nil
+}
+
 // Read reads data from the track. If this is a local track this will error
 func (t *Track) Read(b []byte) (n int, err error) {
+	for {
+		t.mu.RLock()
+		queue := t.readAhead
+		t.mu.RUnlock()
+
+		if queue != nil {
+			n, err = t.readQueued(queue, b)
+		} else {
+			n, err = t.read(b)
+		}
+		if errors.Is(err, errTrackReadAheadStopped) {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		if !t.receiveState.update(b[:n]) {
+			t.mu.Unlock()
+			continue
+		}
+		// The header is kept to parse the extensions of, only if they are asked for
+		t.lastHeader = t.lastHeader[:0]
+		if offset := rtpPayloadOffset(b[:n]); offset != -1 {
+			t.lastHeader = append(t.lastHeader, b[:offset]...)
+		}
+		t.lastPadding = rtpPaddingLength(b[:n])
+		t.lastActivity = t.now()
+		t.mu.Unlock()
+		return
+	}
+}
+
+// AuthenticatedPackets returns how many packets received for the SSRC of a
+// remote Track passed SRTP authentication and replay protection. Only these
+// packets are delivered to a Track, they are counted as they are read from the
+// SRTP session, by Read or while reading ahead. Packets that fail are dropped,
+// and reported by the OnDecryptError handler of the DTLSTransport if one is set.
+// Packets imported with ImportReceiveState aren't counted.
+func (t *Track) AuthenticatedPackets() uint64 {
+	return atomic.LoadUint64(&t.authenticated)
+}
+
+// Bitrate returns the rate packets were received on a remote Track at over the
+// last second, in bits per second. RTP headers and padding are included. Packets
+// are measured as they are read from the SRTP session, by Read or while reading
+// ahead, so packets that are later dropped because of SetMaxLatency or the receive
+// memory limit count as well. Packets are only read from the session while the
+// Track is being read, or reading ahead.
+//
+// Packets are only measured while the Track belongs to a TrackGroup, Bitrate is
+// 0 for a Track that was never added to one.
+func (t *Track) Bitrate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.receiveBitrate.bitrate(t.now())
+}
+
+func (t *Track) bitrateAt(now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.receiveBitrate.bitrate(now)
+}
+
+// LastPaddingLength returns how many bytes of the last packet read from a remote
+// Track are padding, including the trailing padding count byte. The padding is
+// still part of the packet returned by Read, this allows distinguishing it from
+// payload when accounting overhead or recomputing padding in a relay
+func (t *Track) LastPaddingLength() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lastPadding
+}
+
+// LastExtensions returns the IDs of the RFC 8285 header extensions carried by
+// the last packet read from a remote Track, in the order they appear in the packet.
+// This is useful to confirm that a remote peer sends the extensions that were negotiated
+func (t *Track) LastExtensions() []uint8 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]uint8{}, rtpHeaderExtensionIDs(t.lastHeader)...)
+}
+
+// read returns the next buffered packet, or reads one from the RTPReceiver
+func (t *Track) read(b []byte) (n int, err error) {
 	t.mu.RLock()
 	r := t.receiver
 
 	if t.totalSenderCount != 0 || r == nil {
 		t.mu.RUnlock()
 		return 0, errTrackLocalTrackRead
+	} else if atomic.LoadInt32(&t.receiveStateExported) != 0 {
+		t.mu.RUnlock()
+		return 0, ErrTrackReceiveStateExported
 	}
-	peeked := t.peeked != nil
+	buffered := len(t.buffered) != 0
 	t.mu.RUnlock()
 
-	if peeked {
+	if buffered {
 		t.mu.Lock()
-		data := t.peeked
-		t.peeked = nil
+		var data []byte
+		if len(t.buffered) != 0 {
+			data = t.buffered[0]
+			t.buffered = t.buffered[1:]
+		}
 		t.mu.Unlock()
 		// someone else may have stolen our packet when we
 		// released the lock.  Deal with it.
@@ -126,23 +426,238 @@ func (t *Track) Read(b []byte) (n int, err error) {
 		}
 	}
 
-	return r.readRTP(b, t)
+	// Packets read from the SRTP session passed authentication. They are
+	// accounted as received here, whether or not Read returns them
+	n, err = r.readRTP(b, t)
+	if err != nil {
+		return
+	}
+
+	atomic.AddUint64(&t.authenticated, 1)
+	if atomic.LoadInt32(&t.bitrateGroups) != 0 {
+		t.mu.Lock()
+		t.receiveBitrate.add(t.now(), n)
+		t.mu.Unlock()
+	}
+	if atomic.LoadInt32(&t.receiveStateExported) != 0 {
+		return 0, ErrTrackReceiveStateExported
+	}
+	return
+}
+
+// readAheadQueue holds the packets of a remote Track that were read ahead by
+// readAheadLoop, along with when they were received
+type readAheadQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	packets  [][]byte
+	arrivals []time.Time
+	evicted  uint64
+	err      error
+
+	// stopping is set once the queue is no longer needed, readAheadLoop hands
+	// the queued packets back to the Track when it reads the next one
+	stopping bool
+
+	// memory is the pool the queued packets are accounted against, or nil
+	memory *receiveMemoryPool
+}
+
+// pop removes the oldest packet of the queue, the queue must not be empty
+func (q *readAheadQueue) pop() (data []byte, arrival time.Time) {
+	data, arrival = q.packets[0], q.arrivals[0]
+	q.packets, q.arrivals = q.packets[1:], q.arrivals[1:]
+	return
+}
+
+// SetMaxLatency bounds how long a packet received on a remote Track may wait
+// before being returned by Read. Packets that waited longer, for example because
+// the application fell behind, are dropped so Read always returns the freshest
+// data available. This prefers freshness over completeness, and is meant for
+// applications where late data is useless. Zero disables the bound, which is the default.
+//
+// Setting a non-zero bound starts a goroutine dedicated to this Track that reads
+// packets as soon as they are received, to know how long each has been waiting.
+// It exits once the Track is closed, or once the next packet is received after
+// the bound is disabled again. If SettingEngine.SetReceiveMemoryLimit is set the
+// goroutine keeps running, the Track reads ahead to account for its packets.
+func (t *Track) SetMaxLatency(d time.Duration) {
+	t.mu.Lock()
+	t.maxLatency = d
+	q := t.readAhead
+	t.mu.Unlock()
+
+	if d > 0 {
+		t.startReadAhead()
+	} else if q != nil && q.memory == nil {
+		q.mu.Lock()
+		q.stopping = true
+		q.mu.Unlock()
+	}
+}
+
+// SetDropPriority sets which Tracks lose packets first when the receive memory
+// limit set with SettingEngine.SetReceiveMemoryLimit is hit. Packets are dropped
+// from the remote Tracks with the lowest priority first, so for example audio
+// can be given a higher priority than video to keep it intact while video
+// packets are evicted. Default is 0.
+func (t *Track) SetDropPriority(p int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropPriority = p
+}
+
+// DropPriority returns the priority set with SetDropPriority
+func (t *Track) DropPriority() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.dropPriority
+}
+
+// startReadAhead starts a goroutine reading the packets of a remote Track as
+// soon as they are received, if one isn't running already
+func (t *Track) startReadAhead() {
+	t.mu.RLock()
+	q := t.readAhead
+	t.mu.RUnlock()
+
+	// Keep the queue if it is being stopped, unless it already stopped
+	if q != nil {
+		q.mu.Lock()
+		q.stopping = false
+		q.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	if t.readAhead != nil {
+		t.mu.Unlock()
+		return
+	}
+
+	queue := &readAheadQueue{}
+	queue.cond = sync.NewCond(&queue.mu)
+	if t.receiver != nil && t.receiver.api != nil {
+		queue.memory = t.receiver.api.receiveMemory
+	}
+	t.readAhead = queue
+	t.mu.Unlock()
+
+	go t.readAheadLoop(queue)
+}
+
+func (t *Track) readAheadLoop(q *readAheadQueue) {
+	b := make([]byte, receiveMTU)
+	for {
+		n, err := t.read(b)
+
+		t.mu.RLock()
+		maxLatency, now := t.maxLatency, t.now()
+		t.mu.RUnlock()
+
+		if err != nil {
+			q.mu.Lock()
+			q.err = err
+			q.cond.Broadcast()
+			q.mu.Unlock()
+
+			if q.memory != nil {
+				q.memory.remove(q)
+			}
+			return
+		}
+
+		if q.memory != nil && !q.memory.reserve(t, q, n) {
+			continue
+		}
+
+		// Discard what is already too old to be read, so the queue doesn't grow
+		// while the application isn't reading
+		discarded := 0
+		q.mu.Lock()
+		if q.err != nil {
+			// The receive state was exported while the packet was being read
+			q.mu.Unlock()
+			if q.memory != nil {
+				q.memory.remove(q)
+			}
+			return
+		}
+		for len(q.packets) != 0 && maxLatency > 0 && now.Sub(q.arrivals[0]) > maxLatency {
+			data, _ := q.pop()
+			discarded += len(data)
+		}
+		q.packets = append(q.packets, append([]byte{}, b[:n]...))
+		q.arrivals = append(q.arrivals, now)
+
+		if q.stopping {
+			// Read continues with the queued packets, and then reads the
+			// Track itself. Readers waiting on the queue retry
+			t.mu.Lock()
+			t.buffered = append(q.packets, t.buffered...)
+			t.readAhead = nil
+			t.mu.Unlock()
+
+			q.packets, q.arrivals = nil, nil
+			q.err = errTrackReadAheadStopped
+			q.cond.Broadcast()
+			q.mu.Unlock()
+			return
+		}
+		q.cond.Signal()
+		q.mu.Unlock()
+
+		if q.memory != nil {
+			q.memory.release(q, discarded)
+		}
+	}
+}
+
+// readQueued returns the oldest packet read ahead by readAheadLoop that has not
+// exceeded the max latency, dropping those that have
+func (t *Track) readQueued(q *readAheadQueue, b []byte) (n int, err error) {
+	released := 0
+	if q.memory != nil {
+		// Released once q.mu is unlocked, the memory pool locks queues while evicting
+		defer func() { q.memory.release(q, released) }()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.packets) == 0 && q.err == nil {
+			q.cond.Wait()
+		}
+		if len(q.packets) == 0 {
+			return 0, q.err
+		}
+
+		data, arrival := q.pop()
+		released += len(data)
+
+		t.mu.RLock()
+		maxLatency, now := t.maxLatency, t.now()
+		t.mu.RUnlock()
+
+		if maxLatency == 0 || now.Sub(arrival) <= maxLatency {
+			return copy(b, data), nil
+		}
+	}
 }
 
 // peek is like Read, but it doesn't discard the packet read
 func (t *Track) peek(b []byte) (n int, err error) {
-	n, err = t.Read(b)
+	n, err = t.read(b)
 	if err != nil {
 		return
 	}
 
 	t.mu.Lock()
-	// this might overwrite data if somebody peeked between the Read
-	// and us getting the lock.  Oh well, we'll just drop a packet in
-	// that case.
+	// put the packet back at the front of the buffer, so the
+	// next Read returns it again
 	data := make([]byte, n)
 	n = copy(data, b[:n])
-	t.peeked = data
+	t.buffered = append([][]byte{data}, t.buffered...)
 	t.mu.Unlock()
 	return
 }
@@ -162,6 +677,43 @@ func (t *Track) ReadRTP() (*rtp.Packet, error) {
 	return r, nil
 }
 
+// OnRTP sets an event handler which is called for each RTP packet received on
+// a remote Track. Setting the first handler starts a goroutine dedicated to this
+// Track that reads packets and calls the handler in order. The goroutine exits
+// once the Track is closed. Subsequent calls replace the handler.
+//
+// The handler runs on the reading goroutine, so it must return promptly. While
+// it is blocked packets queue in the receive buffer, and are dropped once the
+// buffer is full. OnRTP must not be combined with calls to Read or ReadRTP.
+func (t *Track) OnRTP(f func(*rtp.Packet)) {
+	t.mu.Lock()
+	t.onRTPHandler = f
+	start := !t.onRTPStarted
+	t.onRTPStarted = true
+	t.mu.Unlock()
+
+	if start {
+		go t.readRTPLoop()
+	}
+}
+
+func (t *Track) readRTPLoop() {
+	for {
+		p, err := t.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		t.mu.RLock()
+		handler := t.onRTPHandler
+		t.mu.RUnlock()
+
+		if handler != nil {
+			handler(p)
+		}
+	}
+}
+
 // Write writes data to the track. If this is a remote track this will error
 func (t *Track) Write(b []byte) (n int, err error) {
 	packet := &rtp.Packet{}
@@ -178,68 +730,1041 @@ func (t *Track) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
-// WriteSample packetizes and writes to the track
-func (t *Track) WriteSample(s media.Sample) error {
-	packets := t.packetizer.Packetize(s.Data, s.Samples)
-	for _, p := range packets {
-		err := t.WriteRTP(p)
-		if err != nil {
-			return err
-		}
+// OnOneWayMedia sets an event handler which is called when media flows in only
+// one direction of the Track pair this Track belongs to. A local and a remote Track
+// form a pair when they are sent and received by the same RTPTransceiver. Media
+// is considered one-way when one Track of the pair is active, while nothing has
+// been written to (for a local Track) or read from (for a remote Track) the other
+// one for the threshold set with SetOneWayMediaThreshold, 5 seconds by default.
+// This commonly happens when a NAT or firewall blocks one
+// direction, and can be recovered from with an ICE restart.
+//
+// The handler is called once each time media becomes one-way. Setting the first
+// handler starts a goroutine dedicated to this Track that monitors the pair, it
+// exits once the Track is no longer sent or received, or the handler is set to nil.
+func (t *Track) OnOneWayMedia(f func()) {
+	t.mu.Lock()
+	t.onOneWayMediaHandler = f
+	start := !t.oneWayMediaStarted
+	t.oneWayMediaStarted = true
+	threshold := t.oneWayMediaThreshold
+	if threshold <= 0 {
+		threshold = oneWayMediaThreshold
 	}
+	t.mu.Unlock()
 
-	return nil
+	if start {
+		go t.oneWayMediaLoop(threshold)
+	}
 }
 
-// WriteRTP writes RTP packets to the track
-func (t *Track) WriteRTP(p *rtp.Packet) error {
-	t.mu.RLock()
-	if t.receiver != nil {
-		t.mu.RUnlock()
-		return errTrackLocalTrackWrite
+// SetOneWayMediaThreshold sets how long media may flow in only one direction
+// before the OnOneWayMedia handler is called. The threshold is read when
+// monitoring starts, so it must be set before the first OnOneWayMedia handler.
+// Media is checked four times per threshold, but at most once per millisecond.
+// Zero or a negative duration restores the default of 5 seconds.
+func (t *Track) SetOneWayMediaThreshold(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.oneWayMediaThreshold = d
+}
+
+func (t *Track) oneWayMediaLoop(threshold time.Duration) {
+	interval := threshold / 4
+	if interval < oneWayMediaMinCheckInterval {
+		interval = oneWayMediaMinCheckInterval
 	}
-	senders := t.activeSenders
-	totalSenderCount := t.totalSenderCount
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	t.mu.RLock()
+	monitorStart := t.now()
 	t.mu.RUnlock()
 
-	if totalSenderCount == 0 {
-		return io.ErrClosedPipe
-	}
+	// idle returns if a Track had no activity during the threshold
+	idle := func(track *Track, now time.Time) bool {
+		track.mu.RLock()
+		defer track.mu.RUnlock()
 
-	writeErrs := []error{}
-	for _, s := range senders {
-		if _, err := s.SendRTP(&p.Header, p.Payload); err != nil {
-			writeErrs = append(writeErrs, err)
+		lastActivity := track.lastActivity
+		if lastActivity.Before(monitorStart) {
+			lastActivity = monitorStart
 		}
+		return now.Sub(lastActivity) >= threshold
 	}
 
-	return util.FlattenErrs(writeErrs)
-}
+	oneWay := false
+	for range ticker.C {
+		// A handler set after the loop stopped starts another one
+		t.mu.Lock()
+		handler, now := t.onOneWayMediaHandler, t.now()
+		stopped := handler == nil || t.sendersStopped()
+		if stopped {
+			t.oneWayMediaStarted = false
+		}
+		t.mu.Unlock()
 
-// NewTrack initializes a new *Track
-func NewTrack(payloadType uint8, ssrc uint32, id, label string, codec *RTPCodec) (*Track, error) {
-	if ssrc == 0 {
-		return nil, errTrackSSRCNewTrackZero
+		if stopped {
+			return
+		}
+
+		paired, closed := t.pairedTrack()
+		if closed {
+			return
+		}
+
+		detected := paired != nil && idle(t, now) != idle(paired, now)
+		if detected && !oneWay {
+			go handler()
+		}
+		oneWay = detected
 	}
+}
 
-	packetizer := rtp.NewPacketizer(
-		rtpOutboundMTU,
-		payloadType,
-		ssrc,
-		codec.Payloader,
-		rtp.NewRandomSequencer(),
-		codec.ClockRate,
-	)
+// pairedTrack returns the Track sent or received by the same RTPTransceiver as
+// this one, and if the RTPReceiver of a remote Track has been closed
+func (t *Track) pairedTrack() (paired *Track, closed bool) {
+	t.mu.RLock()
+	receiver := t.receiver
+	senders := append([]*RTPSender{}, t.activeSenders...)
+	t.mu.RUnlock()
 
-	return &Track{
-		id:          id,
-		payloadType: payloadType,
-		kind:        codec.Type,
-		label:       label,
-		ssrc:        ssrc,
-		codec:       codec,
-		packetizer:  packetizer,
-	}, nil
+	if receiver != nil {
+		select {
+		case <-receiver.closed:
+			return nil, true
+		default:
+		}
+
+		if transceiver := receiver.getTransceiver(); transceiver != nil {
+			if sender := transceiver.Sender(); sender != nil {
+				return sender.Track(), false
+			}
+		}
+		return nil, false
+	}
+
+	for _, sender := range senders {
+		if transceiver := sender.getTransceiver(); transceiver != nil {
+			if receiver := transceiver.Receiver(); receiver != nil {
+				if track := receiver.Track(); track != nil {
+					return track, false
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// SetEmptySamplePolicy sets how WriteSample handles a sample with no Data.
+// The default is EmptySamplePolicySkip
+func (t *Track) SetEmptySamplePolicy(policy EmptySamplePolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emptySamplePolicy = policy
+}
+
+// SetMaxPacketsPerSample limits how many packets a single sample passed to
+// WriteSample may be packetized into. A sample that exceeds the limit is dropped,
+// and WriteSample returns ErrTooManyPackets instead of flooding the transport.
+// Sequence numbers stay continuous across dropped samples. Zero disables the
+// limit, which is the default.
+func (t *Track) SetMaxPacketsPerSample(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxPackets = n
+}
+
+// OnClockRateMismatch sets an event handler which is called when the Samples
+// passed to WriteSample don't match the clock rate of the Track's codec. This
+// usually means media captured at one rate (e.g. 44.1kHz) is being sent on a
+// Track created for another (e.g. 48kHz), and will play at the wrong speed.
+//
+// Samples are compared against wall clock time over a window of one second, so
+// the handler fires at the earliest one second after writing starts. It fires once
+// when a mismatch is detected, and may fire again after the rate has matched for a window.
+func (t *Track) OnClockRateMismatch(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onClockRateMismatchHandler = f
+}
+
+// checkClockRate accounts samples against the wall clock and returns the
+// OnClockRateMismatch handler if a mismatch has just been detected. Must be called with t.mu held
+func (t *Track) checkClockRate(samples uint32) func() {
+	if t.codec == nil || t.codec.ClockRate == 0 {
+		return nil
+	}
+
+	now := t.now()
+	m := &t.clockRate
+	defer func() {
+		m.lastWrite = now
+	}()
+
+	// Restart the window if this is the first write, or writing has paused
+	if m.windowStart.IsZero() || now.Sub(m.lastWrite) > clockRateMismatchWindow {
+		m.windowStart = now
+		m.samples = uint64(samples)
+		return nil
+	}
+
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < clockRateMismatchWindow {
+		m.samples += uint64(samples)
+		return nil
+	}
+
+	expected := elapsed.Seconds() * float64(t.codec.ClockRate)
+	mismatched := math.Abs(float64(m.samples)/expected-1) > clockRateMismatchTolerance
+
+	m.windowStart = now
+	m.samples = uint64(samples)
+
+	wasMismatched := m.mismatched
+	m.mismatched = mismatched
+	if mismatched && !wasMismatched {
+		return t.onClockRateMismatchHandler
+	}
+	return nil
+}
+
+func (t *Track) now() time.Time {
+	if t.timegen != nil {
+		return t.timegen()
+	}
+	return time.Now()
+}
+
+// WriteSample packetizes and writes to the track
+func (t *Track) WriteSample(s media.Sample) error {
+	start := time.Now()
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.mu.Lock()
+	packetizer, rewriter := t.packetizer, &t.sampleRewriter
+	if s.SpatialLayer != 0 {
+		if int(s.SpatialLayer) > len(t.spatialLayers) {
+			t.mu.Unlock()
+			return errTrackSpatialLayerUnknown
+		}
+		layer := &t.spatialLayers[s.SpatialLayer-1]
+		packetizer, rewriter = layer.packetizer, &layer.sampleRewriter
+	}
+
+	if len(s.Data) == 0 {
+		policy := t.emptySamplePolicy
+		var mismatchHandler func()
+		if policy == EmptySamplePolicyAdvance {
+			rewriter.advance(s.Samples)
+			if s.SpatialLayer == 0 {
+				mismatchHandler = t.checkClockRate(s.Samples)
+			}
+		}
+		t.mu.Unlock()
+
+		if mismatchHandler != nil {
+			go mismatchHandler()
+		}
+		if policy == EmptySamplePolicyError {
+			return ErrEmptySample
+		}
+		return nil
+	}
+	packets := packetizer.Packetize(s.Data, s.Samples)
+	if t.maxPackets > 0 && len(packets) > t.maxPackets {
+		rewriter.drop(packets)
+		t.mu.Unlock()
+		return fmt.Errorf("%w: %d > %d", ErrTooManyPackets, len(packets), t.maxPackets)
+	}
+	rewriter.rewrite(packets, s.Samples)
+
+	// The spatial layers share the samples of the base layer
+	var mismatchHandler func()
+	if s.SpatialLayer == 0 {
+		mismatchHandler = t.checkClockRate(s.Samples)
+	}
+	t.mu.Unlock()
+
+	if mismatchHandler != nil {
+		go mismatchHandler()
+	}
+
+	defer t.updatePacketizationLatency(start)
+	for _, p := range packets {
+		err := t.WriteRTP(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PacketizationLatency returns the moving average of how long WriteSample takes
+// to packetize a sample and write the packets to all RTPSenders of a local Track.
+// Samples without Data are not accounted. An increase indicates a slow
+// RTPSender, or contention for the Track.
+func (t *Track) PacketizationLatency() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.packetizationLatency
+}
+
+func (t *Track) updatePacketizationLatency(start time.Time) {
+	latency := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.packetizationLatency == 0 {
+		t.packetizationLatency = latency
+	} else {
+		t.packetizationLatency += (latency - t.packetizationLatency) / packetizationLatencyWeight
+	}
+}
+
+// spatialLayer is a SVC spatial layer sent with its own SSRC
+type spatialLayer struct {
+	ssrc           uint32
+	packetizer     rtp.Packetizer
+	sampleRewriter sampleRewriter
+}
+
+// SetSpatialLayerSSRCs sets the SSRCs SVC spatial layers are sent with, when
+// each spatial layer is sent as its own RTP stream (K-SVC). The base layer is
+// sent with the SSRC of the Track, and spatial layer N with ssrcs[N-1]. Samples
+// are routed by their SpatialLayer, and each layer has its own sequence numbers
+// and timestamps. RTCP sent for the layer SSRCs, such as PLI and NACK, is read
+// through the RTPSenders of the Track along with the RTCP of the base layer.
+// The SSRCs are signaled with a SIM ssrc-group, so they must be set before negotiating. Empty ssrcs only sends the base layer, which is the default.
+func (t *Track) SetSpatialLayerSSRCs(ssrcs []uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.receiver != nil {
+		return errTrackLocalTrackWrite
+	}
+
+	seen := map[uint32]bool{t.ssrc: true}
+	layers := make([]spatialLayer, 0, len(ssrcs))
+	for _, ssrc := range ssrcs {
+		if ssrc == 0 || seen[ssrc] {
+			return errTrackSpatialLayerSSRCInvalid
+		}
+		seen[ssrc] = true
+
+		layers = append(layers, spatialLayer{
+			ssrc: ssrc,
+			packetizer: rtp.NewPacketizer(
+				rtpOutboundMTU,
+				t.payloadType,
+				ssrc,
+				t.codec.Payloader,
+				rtp.NewRandomSequencer(),
+				t.codec.ClockRate,
+			),
+		})
+	}
+
+	t.spatialLayers = layers
+	return nil
+}
+
+// spatialLayerSSRCs returns the SSRCs of the spatial layers above the base layer
+func (t *Track) spatialLayerSSRCs() []uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ssrcs := make([]uint32, 0, len(t.spatialLayers))
+	for _, layer := range t.spatialLayers {
+		ssrcs = append(ssrcs, layer.ssrc)
+	}
+	return ssrcs
+}
+
+// BandwidthEstimate returns the estimated available bandwidth for a local Track
+// in bits per second, or zero if no estimate is available yet. The estimate is
+// updated by REMB feedback read through the RTPSenders of the Track, and by
+// SetBandwidthEstimate.
+func (t *Track) BandwidthEstimate() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.bandwidthEstimate
+}
+
+// SetBandwidthEstimate sets the estimated available bandwidth in bits per second.
+// This allows feeding the result of an estimator implemented outside of pion
+func (t *Track) SetBandwidthEstimate(bitrate int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bandwidthEstimate = bitrate
+}
+
+// SetEncoderSource sets a function that is pulled for samples instead of pushing
+// them with WriteSample. Setting the first source starts a goroutine dedicated to
+// this Track, that calls the source with the current BandwidthEstimate as the
+// bitrate to target, and writes the sample it returns. Samples are paced by their
+// duration from when the source was first called, so an encoder is driven at the
+// rate of the media it produces, and a source that blocks until its next sample
+// is ready is called again right away. When the source falls more than one sample
+// behind, for example because it is slow to start, pacing starts over from the
+// next call instead of catching up. Subsequent calls replace the source.
+//
+// The goroutine exits when the source returns an error (for example io.EOF), or
+// writing the sample fails, for example because the Track was removed. The error
+// is passed to the OnEncoderSourceError handler. Setting a source again after
+// that starts another goroutine. Set the source after the Track has been added
+// to a PeerConnection.
+func (t *Track) SetEncoderSource(source func(targetBitrate int) (media.Sample, error)) {
+	t.mu.Lock()
+	t.encoderSource = source
+	t.encoderSourceGeneration++
+	start := !t.encoderSourceStarted
+	t.encoderSourceStarted = true
+	t.mu.Unlock()
+
+	if start {
+		go t.encoderSourceLoop()
+	}
+}
+
+// OnEncoderSourceError sets an event handler which is called with the error that
+// stopped pulling samples from the source set with SetEncoderSource. This is the
+// error returned by the source, or by writing its sample. The handler is called
+// from the goroutine pulling samples.
+func (t *Track) OnEncoderSourceError(f func(err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onEncoderSourceErrorHandler = f
+}
+
+func (t *Track) encoderSourceLoop() {
+	// next is when the source is due to be called, duration the length of the
+	// last sample it returned
+	var (
+		next     time.Time
+		duration time.Duration
+	)
+	for {
+		// A source set after the loop stopped starts another one
+		t.mu.Lock()
+		source, generation := t.encoderSource, t.encoderSourceGeneration
+		targetBitrate := t.bandwidthEstimate
+		var clockRate uint32
+		if t.codec != nil {
+			clockRate = t.codec.ClockRate
+		}
+		if source == nil {
+			t.encoderSourceStarted = false
+		}
+		t.mu.Unlock()
+
+		if source == nil {
+			return
+		}
+
+		// The schedule starts with the first call, and starts over when the source
+		// falls more than one sample behind, for example while an encoder starts.
+		// Samples that were missed meanwhile aren't caught up with in a burst
+		if called := time.Now(); next.IsZero() || called.Sub(next) > duration {
+			next = called
+		}
+
+		s, err := source(targetBitrate)
+		if err == nil {
+			err = t.WriteSample(s)
+		}
+		if err != nil {
+			// Continue with the source that replaced the failed one, if any
+			t.mu.Lock()
+			handler := t.onEncoderSourceErrorHandler
+			stopped := generation == t.encoderSourceGeneration
+			if stopped {
+				t.encoderSource = nil
+				t.encoderSourceStarted = false
+			}
+			t.mu.Unlock()
+
+			if handler != nil {
+				handler(err)
+			}
+			if stopped {
+				return
+			}
+			continue
+		}
+
+		if clockRate != 0 {
+			duration = time.Duration(s.Samples) * time.Second / time.Duration(clockRate)
+			next = next.Add(duration)
+			time.Sleep(time.Until(next))
+		}
+	}
+}
+
+// TrackSendCounters counts the packets written to a local Track. Packets sent by
+// bandwidth probing are counted separately from media
+type TrackSendCounters struct {
+	MediaPackets uint64
+	MediaBytes   uint64
+	ProbePackets uint64
+	ProbeBytes   uint64
+}
+
+// SendCounters returns the counts of packets written to a local Track
+func (t *Track) SendCounters() TrackSendCounters {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sendCounters
+}
+
+// EnableBandwidthProbing controls if a local Track probes for available
+// bandwidth. While enabled, a short cluster of padding only packets is sent
+// in between samples every second, starting right away. Each cluster lasts 50ms,
+// during which padding is sent at 125% of the current BandwidthEstimate (or at
+// least 100 kbit/s). This allows the remote estimator to observe more bandwidth
+// than media alone uses, so the estimate ramps up faster after congestion clears
+// than with additive increase alone, while keeping the additional traffic low.
+//
+// Probe packets share the sequence number space of media, and are not counted
+// as media by SendCounters. Probing starts once the first sample has been written
+// with WriteSample, and stops when disabled or the Track can no longer be written
+// to. This includes every RTPSender of the Track being stopped, for example by
+// RemoveTrack, whether or not a sample was written.
+func (t *Track) EnableBandwidthProbing(enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case enabled && t.bandwidthProbing == nil:
+		t.bandwidthProbing = make(chan struct{})
+		go t.bandwidthProbeLoop(t.bandwidthProbing, t.bandwidthProbeTicks)
+	case !enabled && t.bandwidthProbing != nil:
+		close(t.bandwidthProbing)
+		t.bandwidthProbing = nil
+	}
+}
+
+func (t *Track) bandwidthProbeLoop(stop chan struct{}, ticks <-chan time.Time) {
+	if ticks == nil {
+		ticker := time.NewTicker(bandwidthProbePeriod)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		if !t.probeCluster(stop) {
+			t.mu.Lock()
+			if t.bandwidthProbing == stop {
+				t.bandwidthProbing = nil
+			}
+			t.mu.Unlock()
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticks:
+		}
+	}
+}
+
+// probeCluster sends the probe packets of one cluster, spread evenly over
+// bandwidthProbeInterval. It returns false once the Track can no longer be written to
+func (t *Track) probeCluster(stop chan struct{}) bool {
+	t.mu.RLock()
+	bitrate := t.bandwidthEstimate / 100 * bandwidthProbeGain
+	t.mu.RUnlock()
+
+	if bitrate < bandwidthProbeMinBitrate {
+		bitrate = bandwidthProbeMinBitrate
+	}
+	probeBytes := bitrate / 8 * int(bandwidthProbeInterval/time.Millisecond) / 1000
+	packetSize := rtpHeaderSize + bandwidthProbePaddingSize
+	count := (probeBytes + packetSize - 1) / packetSize
+	gap := bandwidthProbeInterval / time.Duration(count)
+
+	for i := 0; i < count; i++ {
+		if i != 0 {
+			select {
+			case <-stop:
+				return true
+			case <-time.After(gap):
+			}
+		}
+
+		if !t.probe() {
+			return false
+		}
+	}
+	return true
+}
+
+// probe writes a single probe packet, unless no sample has been written yet.
+// It returns false once the Track can no longer be written to
+func (t *Track) probe() bool {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.mu.Lock()
+	if t.sendersStopped() {
+		t.mu.Unlock()
+		return false
+	}
+
+	sequenceNumber, timestamp, ok := t.sampleRewriter.insert()
+	if !ok {
+		t.mu.Unlock()
+		return true
+	}
+
+	payload := make([]byte, bandwidthProbePaddingSize)
+	payload[len(payload)-1] = bandwidthProbePaddingSize
+	p := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Padding:        true,
+			PayloadType:    t.payloadType,
+			SequenceNumber: sequenceNumber,
+			Timestamp:      timestamp,
+			SSRC:           t.ssrc,
+		},
+		Payload: payload,
+	}
+	t.mu.Unlock()
+
+	return t.writeRTP(p, true) == nil
+}
+
+// sendersStopped returns true if a local Track had RTPSenders, and all of them
+// have been stopped. t.mu must be held
+func (t *Track) sendersStopped() bool {
+	return t.receiver == nil && t.stoppedSenderCount != 0 && t.totalSenderCount == 0
+}
+
+// ReplaySession writes the RTP packets of a session recorded in the RTPDump
+// format to a local Track, honoring the time offsets they were recorded at.
+// It blocks until the recording has been replayed, and returns nil once the
+// end of r is reached.
+//
+// Only the packets of the first SSRC in the recording are replayed, RTCP and
+// packets that can't be parsed are skipped. Packets are rewritten to the SSRC and
+// PayloadType of the Track. Sequence numbers and timestamps are rebased so they
+// continue from the last sample written, and samples written after the replay
+// continue from the last replayed packet. Samples written and probes sent while
+// a session is being replayed wait until the replay has completed.
+func (t *Track) ReplaySession(r io.Reader) error {
+	reader, _, err := rtpdump.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	var (
+		start                time.Time
+		recordedSSRC         uint32
+		last                 *rtp.Packet
+		sequenceNumberOffset uint16
+		timestampOffset      uint32
+		frameDuration        uint32
+	)
+	for {
+		recorded, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		} else if recorded.IsRTCP {
+			continue
+		}
+
+		p := &rtp.Packet{}
+		if err := p.Unmarshal(recorded.Payload); err != nil {
+			continue
+		}
+
+		if last == nil {
+			recordedSSRC, start = p.SSRC, time.Now().Add(-recorded.Offset)
+
+			t.mu.RLock()
+			if t.sampleRewriter.started {
+				sequenceNumberOffset = t.sampleRewriter.nextSequenceNumber - p.SequenceNumber
+				timestampOffset = t.sampleRewriter.nextTimestamp - p.Timestamp
+			}
+			t.mu.RUnlock()
+		} else if p.SSRC != recordedSSRC {
+			continue
+		}
+
+		t.mu.RLock()
+		p.SSRC, p.PayloadType = t.ssrc, t.payloadType
+		t.mu.RUnlock()
+		p.SequenceNumber += sequenceNumberOffset
+		p.Timestamp += timestampOffset
+
+		if wait := time.Until(start.Add(recorded.Offset)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if err := t.WriteRTP(p); err != nil {
+			return err
+		}
+		if last != nil && last.Timestamp != p.Timestamp {
+			frameDuration = p.Timestamp - last.Timestamp
+		}
+		last = p
+	}
+
+	if last != nil {
+		t.mu.Lock()
+		t.sampleRewriter.replayed(last, frameDuration)
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// SetCSRCMapping sets a table mapping the SSRCs of the streams aggregated into a
+// local Track, for example the inputs of an audio mixer, to the CSRC identifying
+// each of them in the output. Packets passed to WriteRTP are rewritten as follows
+// * CSRC entries found in the table are replaced with the mapped CSRC
+// * packets with a SSRC found in the table are forwarded from that input, so they
+// are sent with the SSRC of the Track and the mapped CSRC is put first in their CSRC list
+//
+// At most 15 CSRC entries are sent per packet, entries past the first 15 are
+// dropped. The packets passed to WriteRTP aren't modified.
+//
+// Forwarded packets keep their sequence numbers and timestamps, so only one input
+// may be forwarded at a time, otherwise the Track carries several interleaved
+// sequence number spaces. Mix the inputs into packets sent with the SSRC of the
+// Track, or splice them with a StreamSplicer, to aggregate several at once.
+// A nil or empty table disables rewriting, which is the default.
+func (t *Track) SetCSRCMapping(mapping map[uint32]uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.csrcMapping = nil
+	if len(mapping) != 0 {
+		t.csrcMapping = make(map[uint32]uint32, len(mapping))
+		for ssrc, csrc := range mapping {
+			t.csrcMapping[ssrc] = csrc
+		}
+	}
+}
+
+// WriteRTP writes RTP packets to the track
+func (t *Track) WriteRTP(p *rtp.Packet) error {
+	return t.writeRTP(p, false)
+}
+
+// mapCSRC returns the header a packet is sent with according to the CSRC mapping.
+// The CSRC of a forwarded input comes first, so it is kept when the list is truncated
+func mapCSRC(header rtp.Header, ssrc uint32, mapping map[uint32]uint32) rtp.Header {
+	csrc := make([]uint32, 0, len(header.CSRC)+1)
+	forwarded, isForwarded := mapping[header.SSRC]
+	if isForwarded {
+		header.SSRC = ssrc
+		csrc = append(csrc, forwarded)
+	}
+
+	for _, c := range header.CSRC {
+		if mapped, ok := mapping[c]; ok {
+			c = mapped
+		}
+		if !isForwarded || c != forwarded {
+			csrc = append(csrc, c)
+		}
+	}
+
+	if len(csrc) > rtpMaxCSRC {
+		csrc = csrc[:rtpMaxCSRC]
+	}
+	header.CSRC = csrc
+	return header
+}
+
+func (t *Track) writeRTP(p *rtp.Packet, probe bool) error {
+	t.mu.RLock()
+	if t.receiver != nil {
+		t.mu.RUnlock()
+		return errTrackLocalTrackWrite
+	}
+	senders := t.activeSenders
+	totalSenderCount := t.totalSenderCount
+	header := p.Header
+	if t.csrcMapping != nil {
+		header = mapCSRC(header, t.ssrc, t.csrcMapping)
+	}
+	t.mu.RUnlock()
+
+	if totalSenderCount == 0 {
+		return io.ErrClosedPipe
+	}
+
+	writeErrs := []error{}
+	for _, s := range senders {
+		if _, err := s.SendRTP(&header, p.Payload); err != nil {
+			writeErrs = append(writeErrs, err)
+		}
+	}
+
+	size := uint64(header.MarshalSize() + len(p.Payload))
+	t.mu.Lock()
+	if len(writeErrs) < len(senders) {
+		t.lastActivity = t.now()
+	}
+	if probe {
+		t.sendCounters.ProbePackets++
+		t.sendCounters.ProbeBytes += size
+	} else {
+		t.sendCounters.MediaPackets++
+		t.sendCounters.MediaBytes += size
+	}
+	t.mu.Unlock()
+
+	return util.FlattenErrs(writeErrs)
+}
+
+// sampleRewriter rewrites the sequence numbers and timestamps of the packets
+// generated by WriteSample, so they stay continuous when the Packetizer changes
+type sampleRewriter struct {
+	sequenceNumberOffset uint16
+	timestampOffset      uint32
+
+	// next values expected after the last sample written
+	started            bool
+	nextSequenceNumber uint16
+	nextTimestamp      uint32
+
+	// timestamp of the last sample written
+	lastTimestamp uint32
+
+	// set when the Packetizer has been replaced, or packets were replayed.
+	// rebaseTimestamp continues the timestamps even if continueTimestamp isn't set
+	rebase          bool
+	rebaseTimestamp bool
+
+	// timestamp of the first packet, if set by SetInitialTimestamp
+	initialTimestamp *uint32
+
+	resetSequenceNumber bool
+	continueTimestamp   bool
+}
+
+// reset is called when the Packetizer has been replaced
+func (r *sampleRewriter) reset() {
+	r.rebase = true
+}
+
+// advance moves the timestamp of the next sample without sending anything
+func (r *sampleRewriter) advance(samples uint32) {
+	r.timestampOffset += samples
+	r.nextTimestamp += samples
+}
+
+// drop is called for packets that were generated but won't be sent, so
+// the sequence numbers they consumed are reused by the next packets
+func (r *sampleRewriter) drop(packets []*rtp.Packet) {
+	if !r.rebase {
+		r.sequenceNumberOffset -= uint16(len(packets))
+	}
+}
+
+func (r *sampleRewriter) rewrite(packets []*rtp.Packet, samples uint32) {
+	if len(packets) == 0 {
+		return
+	}
+
+	if r.rebase {
+		r.rebase = false
+		r.sequenceNumberOffset = 0
+		r.timestampOffset = 0
+		if r.started && !r.resetSequenceNumber {
+			r.sequenceNumberOffset = r.nextSequenceNumber - packets[0].SequenceNumber
+		}
+		if r.started && (r.continueTimestamp || r.rebaseTimestamp) {
+			r.timestampOffset = r.nextTimestamp - packets[0].Timestamp
+		}
+		r.rebaseTimestamp = false
+	}
+
+	if !r.started && r.initialTimestamp != nil {
+		r.timestampOffset = *r.initialTimestamp - packets[0].Timestamp
+	}
+
+	for _, p := range packets {
+		p.SequenceNumber += r.sequenceNumberOffset
+		p.Timestamp += r.timestampOffset
+	}
+
+	r.started = true
+	r.nextSequenceNumber = packets[len(packets)-1].SequenceNumber + 1
+	r.lastTimestamp = packets[0].Timestamp
+	r.nextTimestamp = packets[0].Timestamp + samples
+}
+
+// replayed is called once packets were written bypassing the Packetizer, so the
+// next sample continues from the last of them. frameDuration is how far the
+// timestamp of the next sample is from the last packet
+func (r *sampleRewriter) replayed(last *rtp.Packet, frameDuration uint32) {
+	r.started = true
+	r.rebase = true
+	r.rebaseTimestamp = true
+	r.initialTimestamp = nil
+	r.nextSequenceNumber = last.SequenceNumber + 1
+	r.lastTimestamp = last.Timestamp
+	r.nextTimestamp = last.Timestamp + frameDuration
+}
+
+// insert allocates the sequence number and timestamp for a packet sent in
+// between samples. The packets of following samples are shifted to make room
+func (r *sampleRewriter) insert() (sequenceNumber uint16, timestamp uint32, ok bool) {
+	if !r.started || r.rebase {
+		return 0, 0, false
+	}
+
+	sequenceNumber = r.nextSequenceNumber
+	r.nextSequenceNumber++
+	r.sequenceNumberOffset++
+	return sequenceNumber, r.lastTimestamp, true
+}
+
+// rtpPayloadOffset returns where the payload of a raw RTP packet starts, or
+// -1 if the packet is too short for its header
+func rtpPayloadOffset(b []byte) int {
+	if len(b) < rtpHeaderSize {
+		return -1
+	}
+
+	offset := rtpHeaderSize + int(b[0]&0x0F)*4
+	if b[0]&0x10 != 0 {
+		if len(b) < offset+4 {
+			return -1
+		}
+		offset += 4 + int(binary.BigEndian.Uint16(b[offset+2:]))*4
+	}
+
+	if len(b) < offset {
+		return -1
+	}
+	return offset
+}
+
+// rtpPaddingLength returns the length of the padding of a raw RTP packet, or
+// zero if it has none or the padding is malformed
+func rtpPaddingLength(b []byte) int {
+	if len(b) < rtpHeaderSize || b[0]&0x20 == 0 {
+		return 0
+	}
+
+	payloadOffset := rtpPayloadOffset(b)
+	padding := int(b[len(b)-1])
+	if payloadOffset == -1 || padding == 0 || padding > len(b)-payloadOffset {
+		return 0
+	}
+	return padding
+}
+
+// rtpHeaderExtensionIDs returns the IDs of the RFC 8285 header extensions of a raw
+// RTP packet. Nil is returned if the packet has no, or a RFC 3550 header extension
+func rtpHeaderExtensionIDs(b []byte) []uint8 {
+	const (
+		extensionProfileOneByte     = 0xBEDE
+		extensionProfileTwoByte     = 0x1000
+		extensionProfileTwoByteMask = 0xFFF0
+		extensionIDReserved         = 0xF
+	)
+
+	if len(b) < rtpHeaderSize || b[0]&0x10 == 0 {
+		return nil
+	}
+
+	offset := rtpHeaderSize + int(b[0]&0x0F)*4
+	if len(b) < offset+4 {
+		return nil
+	}
+	profile := binary.BigEndian.Uint16(b[offset:])
+	end := offset + 4 + int(binary.BigEndian.Uint16(b[offset+2:]))*4
+	if len(b) < end {
+		return nil
+	}
+
+	var ids []uint8
+	for offset += 4; offset < end; {
+		if b[offset] == 0x00 { // padding
+			offset++
+			continue
+		}
+
+		switch {
+		case profile == extensionProfileOneByte:
+			id := b[offset] >> 4
+			if id == extensionIDReserved {
+				return ids
+			}
+			ids = append(ids, id)
+			offset += 1 + int(b[offset]&0x0F) + 1
+		case profile&extensionProfileTwoByteMask == extensionProfileTwoByte:
+			if offset+1 >= end {
+				return ids
+			}
+			ids = append(ids, b[offset])
+			offset += 2 + int(b[offset+1])
+		default:
+			return nil
+		}
+	}
+
+	return ids
+}
+
+// NewTrack initializes a new *Track
+func NewTrack(payloadType uint8, ssrc uint32, id, label string, codec *RTPCodec) (*Track, error) {
+	if ssrc == 0 {
+		return nil, errTrackSSRCNewTrackZero
+	}
+
+	packetizer := rtp.NewPacketizer(
+		rtpOutboundMTU,
+		payloadType,
+		ssrc,
+		codec.Payloader,
+		rtp.NewRandomSequencer(),
+		codec.ClockRate,
+	)
+
+	return &Track{
+		id:                id,
+		payloadType:       payloadType,
+		kind:              codec.Type,
+		label:             label,
+		ssrc:              ssrc,
+		codec:             codec,
+		packetizer:        packetizer,
+		emptySamplePolicy: EmptySamplePolicySkip,
+	}, nil
+}
+
+// AlignedInitialTimestamps returns an initial RTP timestamp for each of the clock
+// rates, derived from the same wall-clock time. Samples captured at the same
+// moment on Tracks started with these timestamps (see SetInitialTimestamp) carry
+// timestamps that are equally far from the start, relative to each clock rate.
+// Each timestamp is the time elapsed since the Unix epoch to base, expressed in
+// units of the clock rate and wrapped to 32 bits.
+func AlignedInitialTimestamps(clockRates []uint32, base time.Time) []uint32 {
+	seconds := uint64(base.Unix())
+	nanoseconds := uint64(base.Nanosecond())
+
+	timestamps := make([]uint32, len(clockRates))
+	for i, clockRate := range clockRates {
+		rate := uint64(clockRate)
+		timestamps[i] = uint32(seconds*rate + nanoseconds*rate/uint64(time.Second))
+	}
+	return timestamps
 }
 
 // determinePayloadType blocks and reads a single packet to determine the PayloadType for this Track
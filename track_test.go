@@ -3,12 +3,75 @@
 package webrtc
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/pion/logging"
 	"github.com/pion/randutil"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/transport/test"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/rtpdump"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// warmupPayload is carried by the packets newLoopbackTrack sends until
+// the remote Track fires OnTrack
+var warmupPayload = []byte{0xDE, 0xAD, 0xBE, 0xEF}
+
+// newLoopbackTrack connects a PeerConnection pair carrying a single local Track
+// and returns once the answering side has received it
+func newLoopbackTrack(t *testing.T, api *API, payloadType uint8) (pcOffer, pcAnswer *PeerConnection, local, remote *Track) {
+	pcOffer, pcAnswer, err := api.newPair(Configuration{})
+	require.NoError(t, err)
+
+	local, remote = addLoopbackTrack(t, pcOffer, pcAnswer, payloadType)
+	return
+}
+
+// addLoopbackTrack is like newLoopbackTrack, for a pair that hasn't been signaled yet
+func addLoopbackTrack(t *testing.T, pcOffer, pcAnswer *PeerConnection, payloadType uint8) (local, remote *Track) {
+	local, err := pcOffer.NewTrack(payloadType, randutil.NewMathRandomGenerator().Uint32(), "track", "pion")
+	require.NoError(t, err)
+	_, err = pcOffer.AddTrack(local)
+	require.NoError(t, err)
+
+	onTrack := make(chan *Track, 1)
+	pcAnswer.OnTrack(func(track *Track, _ *RTPReceiver) {
+		onTrack <- track
+	})
+	require.NoError(t, signalPair(pcOffer, pcAnswer))
+
+	for {
+		select {
+		case remote = <-onTrack:
+			return
+		case <-time.After(20 * time.Millisecond):
+			_ = local.WriteSample(media.Sample{Data: warmupPayload, Samples: 1})
+		}
+	}
+}
+
+// readMediaRTP reads from a Track returned by newLoopbackTrack, discarding warmup packets
+func readMediaRTP(t *testing.T, track *Track) *rtp.Packet {
+	for {
+		p, err := track.ReadRTP()
+		require.NoError(t, err)
+		if !bytes.HasSuffix(p.Payload, warmupPayload) {
+			return p
+		}
+	}
+}
+
 func TestNewVideoTrack(t *testing.T) {
 	m := MediaEngine{}
 	m.RegisterCodec(NewRTPVP8Codec(DefaultPayloadTypeVP8, 90000))
@@ -128,3 +191,1467 @@ func TestTrackReadWhenNotAdded(t *testing.T) {
 	_, err = track.Read([]byte{})
 	assert.Error(t, err)
 }
+
+func TestTrackEmptySamplePolicy(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("Error", func(t *testing.T) {
+		track, err := NewTrack(DefaultPayloadTypeOpus, 5000, "audio", "pion", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+		assert.NoError(t, err)
+
+		assert.NoError(t, track.WriteSample(media.Sample{Samples: 960}))
+
+		track.SetEmptySamplePolicy(EmptySamplePolicyError)
+		assert.Equal(t, ErrEmptySample, track.WriteSample(media.Sample{Samples: 960}))
+		assert.Equal(t, ErrEmptySample, track.WriteSample(media.Sample{Data: []byte{}, Samples: 960}))
+	})
+
+	t.Run("Skip and Advance", func(t *testing.T) {
+		api := NewAPI()
+		api.mediaEngine.RegisterDefaultCodecs()
+		pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 960}))
+		first := readMediaRTP(t, remote)
+
+		// Skip is the default, the timestamp does not move
+		assert.NoError(t, local.WriteSample(media.Sample{Samples: 960}))
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x02}, Samples: 960}))
+		second := readMediaRTP(t, remote)
+		assert.Equal(t, []byte{0x02}, second.Payload)
+		assert.Equal(t, first.Timestamp+960, second.Timestamp)
+		assert.Equal(t, first.SequenceNumber+1, second.SequenceNumber)
+
+		// Advance moves the timestamp without sending anything
+		local.SetEmptySamplePolicy(EmptySamplePolicyAdvance)
+		assert.NoError(t, local.WriteSample(media.Sample{Samples: 960}))
+		assert.NoError(t, local.WriteSample(media.Sample{Samples: 960}))
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x03}, Samples: 960}))
+		third := readMediaRTP(t, remote)
+		assert.Equal(t, []byte{0x03}, third.Payload)
+		assert.Equal(t, second.Timestamp+960*3, third.Timestamp)
+		assert.Equal(t, second.SequenceNumber+1, third.SequenceNumber)
+
+		closePairNow(t, pcOffer, pcAnswer)
+	})
+}
+
+func TestTrackOnClockRateMismatch(t *testing.T) {
+	writeSamples := func(track *Track, samplesPer20ms uint32, duration time.Duration) {
+		now := time.Unix(0, 0)
+		track.timegen = func() time.Time { return now }
+
+		for elapsed := time.Duration(0); elapsed <= duration; elapsed += 20 * time.Millisecond {
+			// There are no senders, only the clock rate accounting is under test
+			assert.Error(t, track.WriteSample(media.Sample{Data: []byte{0x00}, Samples: samplesPer20ms}))
+			now = now.Add(20 * time.Millisecond)
+		}
+	}
+
+	t.Run("Mismatched", func(t *testing.T) {
+		track, err := NewTrack(DefaultPayloadTypeOpus, 5000, "audio", "pion", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+		assert.NoError(t, err)
+
+		mismatch := make(chan struct{}, 1)
+		track.OnClockRateMismatch(func() {
+			mismatch <- struct{}{}
+		})
+
+		// 20ms of 44.1kHz audio
+		writeSamples(track, media.NSamples(20*time.Millisecond, 44100), 2*time.Second)
+
+		select {
+		case <-mismatch:
+		case <-time.After(time.Second):
+			t.Fatal("OnClockRateMismatch was not called")
+		}
+	})
+
+	t.Run("Matched", func(t *testing.T) {
+		track, err := NewTrack(DefaultPayloadTypeOpus, 5000, "audio", "pion", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+		assert.NoError(t, err)
+
+		mismatch := make(chan struct{}, 1)
+		track.OnClockRateMismatch(func() {
+			mismatch <- struct{}{}
+		})
+
+		writeSamples(track, media.NSamples(20*time.Millisecond, 48000), 2*time.Second)
+
+		// The handler is called from its own goroutine, give it the time to run
+		select {
+		case <-mismatch:
+			t.Fatal("OnClockRateMismatch called for Samples matching the clock rate")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestTrackSetPacketizer(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	newPacketizer := func(sequenceNumber uint16) rtp.Packetizer {
+		return rtp.NewPacketizer(rtpOutboundMTU, DefaultPayloadTypeOpus, local.SSRC(), local.Codec().Payloader, rtp.NewFixedSequencer(sequenceNumber), 48000)
+	}
+	writeAndRead := func(payload byte) *rtp.Packet {
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{payload}, Samples: 960}))
+		p := readMediaRTP(t, remote)
+		assert.Equal(t, []byte{payload}, p.Payload)
+		return p
+	}
+
+	first := writeAndRead(0x01)
+
+	// Sequence numbers continue by default, the timestamp does not
+	local.SetPacketizer(newPacketizer(first.SequenceNumber + 50))
+	second := writeAndRead(0x02)
+	assert.Equal(t, first.SequenceNumber+1, second.SequenceNumber)
+	third := writeAndRead(0x03)
+	assert.Equal(t, second.SequenceNumber+1, third.SequenceNumber)
+	assert.Equal(t, second.Timestamp+960, third.Timestamp)
+
+	// Opt out, the new Packetizer is used as is
+	local.SetPacketizerContinuity(false, false)
+	local.SetPacketizer(newPacketizer(third.SequenceNumber + 50))
+	fourth := writeAndRead(0x04)
+	assert.Equal(t, third.SequenceNumber+50, fourth.SequenceNumber)
+
+	// Continue both sequence number and timestamp
+	local.SetPacketizerContinuity(true, true)
+	local.SetPacketizer(newPacketizer(fourth.SequenceNumber + 50))
+	fifth := writeAndRead(0x05)
+	assert.Equal(t, fourth.SequenceNumber+1, fifth.SequenceNumber)
+	assert.Equal(t, fourth.Timestamp+960, fifth.Timestamp)
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackRTCPMuxed(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("Muxed", func(t *testing.T) {
+		api := NewAPI()
+		api.mediaEngine.RegisterDefaultCodecs()
+		pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+		assert.True(t, local.RTCPMuxed())
+		assert.True(t, remote.RTCPMuxed())
+
+		closePairNow(t, pcOffer, pcAnswer)
+	})
+
+	t.Run("Remote Without rtcp-mux", func(t *testing.T) {
+		// SRTCP isn't authenticated reliably with AEAD_AES_128_GCM
+		s := SettingEngine{}
+		s.SetSRTPProtectionProfiles([]SRTPProtectionProfile{SRTPProtectionProfileAes128CmHmacSha1_80})
+		api := NewAPI(WithSettingEngine(s))
+		api.mediaEngine.RegisterDefaultCodecs()
+		pcOffer, pcAnswer, err := api.newPair(Configuration{})
+		require.NoError(t, err)
+
+		local, err := pcOffer.NewTrack(DefaultPayloadTypeOpus, randutil.NewMathRandomGenerator().Uint32(), "audio", "pion")
+		require.NoError(t, err)
+		sender, err := pcOffer.AddTrack(local)
+		require.NoError(t, err)
+		assert.False(t, local.RTCPMuxed())
+
+		onTrack := make(chan *Track, 1)
+		pcAnswer.OnTrack(func(track *Track, _ *RTPReceiver) {
+			onTrack <- track
+		})
+
+		offer, err := pcOffer.CreateOffer(nil)
+		require.NoError(t, err)
+		offerGatheringComplete := GatheringCompletePromise(pcOffer)
+		require.NoError(t, pcOffer.SetLocalDescription(offer))
+		<-offerGatheringComplete
+		require.NoError(t, pcAnswer.SetRemoteDescription(*pcOffer.LocalDescription()))
+
+		answer, err := pcAnswer.CreateAnswer(nil)
+		require.NoError(t, err)
+		answerGatheringComplete := GatheringCompletePromise(pcAnswer)
+		require.NoError(t, pcAnswer.SetLocalDescription(answer))
+		<-answerGatheringComplete
+
+		// Answer as an endpoint that doesn't signal rtcp-mux
+		answer = *pcAnswer.LocalDescription()
+		answer.SDP = strings.ReplaceAll(answer.SDP, "a=rtcp-mux\r\n", "")
+		require.NoError(t, pcOffer.SetRemoteDescription(answer))
+
+		var remote *Track
+		for remote == nil {
+			select {
+			case remote = <-onTrack:
+			case <-time.After(20 * time.Millisecond):
+				_ = local.WriteSample(media.Sample{Data: []byte{0x00}, Samples: 1})
+			}
+		}
+
+		// RTCP still shares the transport of RTP
+		assert.True(t, local.RTCPMuxed())
+		assert.True(t, remote.RTCPMuxed())
+
+		// RTCP is received on the transport of RTP
+		require.NoError(t, pcAnswer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: remote.SSRC()}}))
+		for received := false; !received; {
+			pkts, err := sender.ReadRTCP()
+			require.NoError(t, err)
+			for _, pkt := range pkts {
+				if _, ok := pkt.(*rtcp.PictureLossIndication); ok {
+					received = true
+				}
+			}
+		}
+
+		closePairNow(t, pcOffer, pcAnswer)
+	})
+}
+
+func TestTrackOnRTP(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	const packetCount = 10
+	packets := make(chan *rtp.Packet, packetCount)
+	remote.OnRTP(func(p *rtp.Packet) {
+		if !bytes.HasSuffix(p.Payload, warmupPayload) {
+			packets <- p
+		}
+	})
+
+	for i := 0; i < packetCount; i++ {
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{byte(i)}, Samples: 960}))
+	}
+
+	var last *rtp.Packet
+	for i := 0; i < packetCount; i++ {
+		p := <-packets
+		assert.Equal(t, []byte{byte(i)}, p.Payload)
+		if last != nil {
+			assert.Equal(t, last.SequenceNumber+1, p.SequenceNumber)
+		}
+		last = p
+	}
+
+	// The read loop exits once the PeerConnection is closed
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackLastExtensions(t *testing.T) {
+	marshal := func(profile uint16, extensions map[uint8][]byte, ids ...uint8) []byte {
+		p := &rtp.Packet{
+			Header:  rtp.Header{Version: 2, SSRC: 5000, CSRC: []uint32{1, 2}},
+			Payload: []byte{0x00},
+		}
+		if len(ids) != 0 {
+			p.Extension = true
+			p.ExtensionProfile = profile
+		}
+		for _, id := range ids {
+			assert.NoError(t, p.SetExtension(id, extensions[id]))
+		}
+
+		b, err := p.Marshal()
+		assert.NoError(t, err)
+		return b
+	}
+
+	extensions := map[uint8][]byte{
+		1:   {0x01},
+		3:   {0x01, 0x02, 0x03},
+		14:  {0x01, 0x02},
+		200: {0x01, 0x02, 0x03, 0x04, 0x05},
+	}
+
+	for _, testCase := range []struct {
+		name        string
+		packet      []byte
+		expectedIDs []uint8
+	}{
+		{"No Extensions", marshal(0, nil), nil},
+		{"One Byte", marshal(0xBEDE, extensions, 3, 1, 14), []uint8{3, 1, 14}},
+		{"Two Byte", marshal(0x1000, extensions, 200, 3), []uint8{200, 3}},
+		{"RFC 3550", marshal(0x0001, map[uint8][]byte{0: {0x01, 0x02, 0x03, 0x04}}, 0), nil},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			track := &Track{receiver: &RTPReceiver{}, buffered: [][]byte{testCase.packet}}
+
+			n, err := track.Read(make([]byte, receiveMTU))
+			assert.NoError(t, err)
+			assert.Equal(t, len(testCase.packet), n)
+			if testCase.expectedIDs == nil {
+				assert.Empty(t, track.LastExtensions())
+			} else {
+				assert.Equal(t, testCase.expectedIDs, track.LastExtensions())
+			}
+		})
+	}
+
+	// The header kept for the last packet is replaced by each packet read
+	track := &Track{receiver: &RTPReceiver{}, buffered: [][]byte{
+		marshal(0x1000, extensions, 200, 3),
+		marshal(0xBEDE, extensions, 1),
+		marshal(0, nil),
+	}}
+	for _, expectedIDs := range [][]uint8{{200, 3}, {1}, {}} {
+		_, err := track.Read(make([]byte, receiveMTU))
+		assert.NoError(t, err)
+		assert.Equal(t, expectedIDs, track.LastExtensions())
+	}
+}
+
+func TestTrackSetMaxPacketsPerSample(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeVP8)
+
+	local.SetMaxPacketsPerSample(2)
+
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 3000}))
+	first := readMediaRTP(t, remote)
+
+	// Fragments into 4 packets
+	oversized := bytes.Repeat([]byte{0x02}, rtpOutboundMTU*3)
+	assert.True(t, errors.Is(local.WriteSample(media.Sample{Data: oversized, Samples: 3000}), ErrTooManyPackets))
+
+	// Fragments into 2 packets
+	assert.NoError(t, local.WriteSample(media.Sample{Data: bytes.Repeat([]byte{0x03}, rtpOutboundMTU), Samples: 3000}))
+	second := readMediaRTP(t, remote)
+	third := readMediaRTP(t, remote)
+	assert.True(t, bytes.HasSuffix(second.Payload, []byte{0x03}))
+	assert.True(t, third.Marker)
+
+	// The dropped sample left no gap in sequence numbers
+	assert.Equal(t, first.SequenceNumber+1, second.SequenceNumber)
+	assert.Equal(t, first.SequenceNumber+2, third.SequenceNumber)
+
+	// Zero disables the limit
+	local.SetMaxPacketsPerSample(0)
+	assert.NoError(t, local.WriteSample(media.Sample{Data: oversized, Samples: 3000}))
+	for i := 0; i < 4; i++ {
+		p := readMediaRTP(t, remote)
+		assert.Equal(t, third.SequenceNumber+uint16(i+1), p.SequenceNumber)
+	}
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackLastPaddingLength(t *testing.T) {
+	marshal := func(padding bool, payload []byte) []byte {
+		b, err := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, Padding: padding, SSRC: 5000},
+			Payload: payload,
+		}).Marshal()
+		assert.NoError(t, err)
+		return b
+	}
+	padded := func(payloadLength, paddingLength int) []byte {
+		payload := make([]byte, payloadLength+paddingLength)
+		payload[len(payload)-1] = byte(paddingLength)
+		return marshal(true, payload)
+	}
+
+	for _, testCase := range []struct {
+		name            string
+		packet          []byte
+		expectedPadding int
+	}{
+		{"No Padding", marshal(false, []byte{0x01, 0x02, 0x03, 0x04}), 0},
+		{"One Byte", padded(10, 1), 1},
+		{"Four Bytes", padded(10, 4), 4},
+		{"Maximum", padded(10, 255), 255},
+		{"Only Padding", padded(0, 16), 16},
+		{"Exceeds Packet", marshal(true, []byte{0x01, 0x02, 0x10}), 0},
+		{"Zero Count", marshal(true, []byte{0x01, 0x02, 0x00}), 0},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			track := &Track{receiver: &RTPReceiver{}, buffered: [][]byte{testCase.packet}}
+
+			_, err := track.Read(make([]byte, receiveMTU))
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedPadding, track.LastPaddingLength())
+		})
+	}
+}
+
+func TestTrackSetEncoderSource(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	// Read RTCP so REMB feedback reaches the Track
+	go func() {
+		for {
+			if _, err := pcOffer.GetSenders()[0].ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+
+	local.SetBandwidthEstimate(500000)
+
+	targets := make(chan int)
+	done := make(chan struct{})
+	count := 0
+	local.SetEncoderSource(func(targetBitrate int) (media.Sample, error) {
+		select {
+		case targets <- targetBitrate:
+		case <-done:
+			return media.Sample{}, io.EOF
+		}
+		count++
+		return media.Sample{Data: []byte{byte(count)}, Samples: 480}, nil
+	})
+
+	for i := 1; i <= 5; i++ {
+		assert.Equal(t, 500000, <-targets)
+		assert.Equal(t, []byte{byte(i)}, readMediaRTP(t, remote).Payload)
+	}
+
+	// The estimate now comes from the remote peer, RTCP may be lost so keep sending it
+	for i := 6; ; i++ {
+		assert.NoError(t, pcAnswer.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 250000, SSRCs: []uint32{local.SSRC()}}}))
+		target := <-targets
+		assert.Equal(t, []byte{byte(i)}, readMediaRTP(t, remote).Payload)
+		if target == 250000 {
+			break
+		}
+	}
+	assert.Equal(t, 250000, local.BandwidthEstimate())
+
+	// The error stopping the source is reported, and a new source starts again
+	sourceErrors := make(chan error, 1)
+	local.OnEncoderSourceError(func(err error) {
+		sourceErrors <- err
+	})
+	close(done)
+	assert.True(t, errors.Is(<-sourceErrors, io.EOF))
+
+	local.SetEncoderSource(func(int) (media.Sample, error) {
+		return media.Sample{Data: []byte{0xFF}, Samples: 480}, io.ErrUnexpectedEOF
+	})
+	assert.True(t, errors.Is(<-sourceErrors, io.ErrUnexpectedEOF))
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackSetEncoderSource_Pacing(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	// A source that blocks until each 20ms sample is ready runs in real time
+	const sampleCount = 10
+	const sampleDuration = 20 * time.Millisecond
+	sourceErrors := make(chan error, 1)
+	local.OnEncoderSourceError(func(err error) {
+		sourceErrors <- err
+	})
+
+	count := 0
+	started := time.Now()
+	local.SetEncoderSource(func(int) (media.Sample, error) {
+		if count == sampleCount {
+			return media.Sample{}, io.EOF
+		}
+		count++
+		time.Sleep(sampleDuration)
+		return media.Sample{Data: []byte{byte(count)}, Samples: 960}, nil
+	})
+
+	for i := 1; i <= sampleCount; i++ {
+		assert.Equal(t, []byte{byte(i)}, readMediaRTP(t, remote).Payload)
+	}
+	assert.True(t, errors.Is(<-sourceErrors, io.EOF))
+
+	elapsed := time.Since(started)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(sampleCount*sampleDuration))
+	assert.Less(t, int64(elapsed), int64(sampleCount*sampleDuration*3/2))
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackSetEncoderSource_SlowStart(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	// The samples missed while the source starts aren't sent in a burst
+	const sampleCount = 10
+	const sampleDuration = 20 * time.Millisecond
+	sourceErrors := make(chan error, 1)
+	local.OnEncoderSourceError(func(err error) {
+		sourceErrors <- err
+	})
+
+	var calls []time.Time
+	local.SetEncoderSource(func(int) (media.Sample, error) {
+		if len(calls) == sampleCount {
+			return media.Sample{}, io.EOF
+		}
+		calls = append(calls, time.Now())
+		if len(calls) == 1 {
+			time.Sleep(10 * sampleDuration)
+		}
+		return media.Sample{Data: []byte{byte(len(calls))}, Samples: 960}, nil
+	})
+
+	for i := 1; i <= sampleCount; i++ {
+		assert.Equal(t, []byte{byte(i)}, readMediaRTP(t, remote).Payload)
+	}
+	assert.True(t, errors.Is(<-sourceErrors, io.EOF))
+
+	for i := 2; i < sampleCount; i++ {
+		assert.GreaterOrEqual(t, int64(calls[i].Sub(calls[1])), int64(time.Duration(i-1)*sampleDuration), "call %d", i)
+	}
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackEnableBandwidthProbing(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 480}))
+	mediaPacket := readMediaRTP(t, remote)
+	mediaCounters := local.SendCounters()
+	assert.Zero(t, mediaCounters.ProbePackets)
+
+	// The first cluster is sent right away, at 125% of the estimate over 50ms
+	const clusterPackets = 30
+	local.SetBandwidthEstimate(1000000)
+	ticks := make(chan time.Time)
+	local.mu.Lock()
+	local.bandwidthProbeTicks = ticks
+	local.mu.Unlock()
+	local.EnableBandwidthProbing(true)
+
+	// Probes continue the sequence numbers of media
+	lastSequenceNumber := mediaPacket.SequenceNumber
+	probes := 0
+	for ; probes < 10; probes++ {
+		p := readMediaRTP(t, remote)
+		assert.True(t, p.Padding)
+		assert.Equal(t, 255, remote.LastPaddingLength())
+		assert.Equal(t, local.SSRC(), p.SSRC)
+		assert.Equal(t, lastSequenceNumber+1, p.SequenceNumber)
+		lastSequenceNumber = p.SequenceNumber
+	}
+
+	// Probes are sent in order with the packets of samples written meanwhile
+	const sampleCount = 20
+	written := make(chan error, sampleCount)
+	go func() {
+		for i := 0; i < sampleCount; i++ {
+			written <- local.WriteSample(media.Sample{Data: []byte{0x02}, Samples: 480})
+		}
+	}()
+	for samples := 0; samples < sampleCount; {
+		p := readMediaRTP(t, remote)
+		assert.Equal(t, lastSequenceNumber+1, p.SequenceNumber)
+		lastSequenceNumber = p.SequenceNumber
+		if p.Padding {
+			probes++
+		} else {
+			samples++
+		}
+	}
+	for i := 0; i < sampleCount; i++ {
+		assert.NoError(t, <-written)
+	}
+
+	// Nothing more is sent until the next cluster
+	time.Sleep(bandwidthProbeInterval * 2)
+	counters := local.SendCounters()
+	assert.Equal(t, uint64(clusterPackets), counters.ProbePackets)
+	assert.Equal(t, counters.ProbePackets*uint64(rtpHeaderSize+255), counters.ProbeBytes)
+	assert.Equal(t, mediaCounters.MediaPackets+sampleCount, counters.MediaPackets)
+
+	// Each period sends another cluster
+	ticks <- time.Now()
+	for ; probes < 2*clusterPackets; probes++ {
+		p := readMediaRTP(t, remote)
+		assert.True(t, p.Padding)
+		assert.Equal(t, lastSequenceNumber+1, p.SequenceNumber)
+		lastSequenceNumber = p.SequenceNumber
+	}
+	local.EnableBandwidthProbing(false)
+
+	// The last probe is counted once writing it returns
+	local.writeMu.Lock()
+	assert.Equal(t, uint64(2*clusterPackets), local.SendCounters().ProbePackets)
+	local.writeMu.Unlock()
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackEnableBandwidthProbing_Removed(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	pc, err := NewPeerConnection(Configuration{})
+	require.NoError(t, err)
+
+	track, err := pc.NewTrack(DefaultPayloadTypeOpus, randutil.NewMathRandomGenerator().Uint32(), "audio", "pion")
+	require.NoError(t, err)
+	sender, err := pc.AddTrack(track)
+	require.NoError(t, err)
+
+	// Probing stops once the Track is removed, even though no sample was written
+	track.EnableBandwidthProbing(true)
+	require.NoError(t, pc.RemoveTrack(sender))
+	for probing := true; probing; {
+		time.Sleep(bandwidthProbeInterval)
+
+		track.mu.RLock()
+		probing = track.bandwidthProbing != nil
+		track.mu.RUnlock()
+	}
+
+	assert.NoError(t, pc.Close())
+}
+
+func TestTrackAuthenticatedPackets(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, err := api.newPair(Configuration{})
+	require.NoError(t, err)
+
+	// The handler must be set before SRTP starts for packets to be authenticated
+	type decryptError struct {
+		ssrc uint32
+		err  error
+	}
+	decryptErrors := make(chan decryptError, 16)
+	pcAnswer.dtlsTransport.OnDecryptError(func(ssrc uint32, err error) {
+		decryptErrors <- decryptError{ssrc, err}
+	})
+
+	local, remote := addLoopbackTrack(t, pcOffer, pcAnswer, DefaultPayloadTypeOpus)
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 480}))
+	mediaPacket := readMediaRTP(t, remote)
+	authenticated := remote.AuthenticatedPackets()
+	assert.NotZero(t, authenticated)
+
+	// Send a packet for the same stream that carries a forged authentication tag
+	tamperedPayload := bytes.Repeat([]byte{0xAA}, 32)
+	tampered, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    mediaPacket.PayloadType,
+			SequenceNumber: mediaPacket.SequenceNumber + 100,
+			Timestamp:      mediaPacket.Timestamp,
+			SSRC:           mediaPacket.SSRC,
+		},
+		Payload: tamperedPayload,
+	}).Marshal()
+	assert.NoError(t, err)
+	_, err = pcOffer.dtlsTransport.srtpEndpoint.Write(tampered)
+	assert.NoError(t, err)
+
+	rejected := <-decryptErrors
+	assert.Equal(t, mediaPacket.SSRC, rejected.ssrc)
+	assert.True(t, errors.Is(rejected.err, ErrSRTPDecryptFailed))
+
+	// Only the authentic packet following the tampered one is delivered and counted
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x02}, Samples: 480}))
+	p, err := remote.ReadRTP()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x02}, p.Payload)
+	assert.Equal(t, authenticated+1, remote.AuthenticatedPackets())
+	assert.Empty(t, decryptErrors)
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestAlignedInitialTimestamps(t *testing.T) {
+	clockRates := []uint32{48000, 90000}
+	base := time.Unix(1600000000, 500000000)
+
+	// 1600000000.5 seconds in units of each clock rate, wrapped to 32 bits
+	timestamps := AlignedInitialTimestamps(clockRates, base)
+	assert.Equal(t, []uint32{76800000024000 % (1 << 32), 144000000045000 % (1 << 32)}, timestamps)
+
+	// Equal wall-clock offsets map to the same offset relative to each clock rate
+	for _, d := range []time.Duration{20 * time.Millisecond, time.Second, 90 * time.Minute} {
+		later := AlignedInitialTimestamps(clockRates, base.Add(d))
+		for i, clockRate := range clockRates {
+			assert.Equal(t, uint32(uint64(d)*uint64(clockRate)/uint64(time.Second)), later[i]-timestamps[i])
+		}
+	}
+
+	assert.Empty(t, AlignedInitialTimestamps(nil, base))
+}
+
+func TestTrackSetInitialTimestamp(t *testing.T) {
+	m := MediaEngine{}
+	m.RegisterCodec(NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+	m.RegisterCodec(NewRTPVP8Codec(DefaultPayloadTypeVP8, 90000))
+	api := NewAPI(WithMediaEngine(m))
+
+	pc, err := api.NewPeerConnection(Configuration{})
+	assert.NoError(t, err)
+
+	timestamps := AlignedInitialTimestamps([]uint32{48000, 90000}, time.Now())
+	for i, payloadType := range []uint8{DefaultPayloadTypeOpus, DefaultPayloadTypeVP8} {
+		track, err := pc.NewTrack(payloadType, randutil.NewMathRandomGenerator().Uint32(), "track", "pion")
+		assert.NoError(t, err)
+
+		// The Track isn't sending, but samples are still packetized and rewritten
+		assert.NoError(t, track.SetInitialTimestamp(timestamps[i]))
+		assert.Equal(t, io.ErrClosedPipe, track.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 960}))
+		assert.Equal(t, timestamps[i], track.sampleRewriter.lastTimestamp)
+
+		assert.Equal(t, io.ErrClosedPipe, track.WriteSample(media.Sample{Data: []byte{0x02}, Samples: 960}))
+		assert.Equal(t, timestamps[i]+960, track.sampleRewriter.lastTimestamp)
+
+		assert.Equal(t, errTrackInitialTimestampAfterWrite, track.SetInitialTimestamp(timestamps[i]))
+	}
+
+	assert.NoError(t, pc.Close())
+}
+
+func TestTrackSetMaxLatency(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	var nowLock sync.Mutex
+	now := time.Unix(0, 0)
+	advance := func(d time.Duration) {
+		nowLock.Lock()
+		now = now.Add(d)
+		nowLock.Unlock()
+	}
+
+	remote.mu.Lock()
+	remote.timegen = func() time.Time {
+		nowLock.Lock()
+		defer nowLock.Unlock()
+		return now
+	}
+	remote.mu.Unlock()
+
+	const maxLatency = 100 * time.Millisecond
+	remote.SetMaxLatency(maxLatency)
+
+	// writeQueued writes a sample, and returns once the remote Track received it
+	writeQueued := func(payload byte) {
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{payload}, Samples: 480}))
+		for {
+			remote.readAhead.mu.Lock()
+			queued := len(remote.readAhead.packets) != 0 &&
+				bytes.HasSuffix(remote.readAhead.packets[len(remote.readAhead.packets)-1], []byte{payload})
+			remote.readAhead.mu.Unlock()
+			if queued {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Packets that waited longer than the bound are dropped
+	writeQueued(0x01)
+	advance(maxLatency + time.Millisecond)
+	writeQueued(0x02)
+	p, err := remote.ReadRTP()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x02}, p.Payload)
+
+	// Packets within the bound are delivered in order
+	writeQueued(0x03)
+	advance(maxLatency / 2)
+	writeQueued(0x04)
+	advance(maxLatency / 2)
+	for _, payload := range []byte{0x03, 0x04} {
+		p, err = remote.ReadRTP()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{payload}, p.Payload)
+	}
+
+	// Disabling the bound stops reading ahead once the next packet is received.
+	// Packets are delivered no matter how old
+	remote.SetMaxLatency(0)
+	for _, payload := range []byte{0x05, 0x06} {
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{payload}, Samples: 480}))
+		advance(time.Hour)
+		p, err = remote.ReadRTP()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{payload}, p.Payload)
+
+		remote.mu.RLock()
+		assert.Nil(t, remote.readAhead)
+		remote.mu.RUnlock()
+	}
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackSetDropPriority(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// Both Tracks send packets of packetSize bytes, the limit fits 10 of them
+	const packetSize = rtpHeaderSize + 100
+	s := SettingEngine{}
+	s.SetReceiveMemoryLimit(10 * packetSize)
+	api := NewAPI(WithSettingEngine(s))
+	api.mediaEngine.RegisterDefaultCodecs()
+
+	pcOfferAudio, pcAnswerAudio, localAudio, remoteAudio := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+	pcOfferVideo, pcAnswerVideo, localVideo, remoteVideo := newLoopbackTrack(t, api, DefaultPayloadTypeVP8)
+	for _, track := range []*Track{localAudio, localVideo} {
+		assert.NoError(t, track.WriteSample(media.Sample{Data: []byte{0x00}, Samples: 1}))
+	}
+	readMediaRTP(t, remoteAudio)
+	readMediaRTP(t, remoteVideo)
+
+	remoteAudio.SetDropPriority(1)
+	assert.Equal(t, 1, remoteAudio.DropPriority())
+	assert.Equal(t, 0, remoteVideo.DropPriority())
+
+	queueState := func(track *Track) (queued int, evicted uint64) {
+		track.readAhead.mu.Lock()
+		defer track.readAhead.mu.Unlock()
+		return len(track.readAhead.packets), track.readAhead.evicted
+	}
+	// write sends packets ending with the given markers, and returns once each was queued or evicted
+	write := func(local, remote *Track, markers ...byte) {
+		size := packetSize - rtpHeaderSize
+		if local.Codec().Name == VP8 {
+			// The VP8 payload descriptor takes a byte
+			size--
+		}
+
+		for _, marker := range markers {
+			queued, evicted := queueState(remote)
+			assert.NoError(t, local.WriteSample(media.Sample{Data: bytes.Repeat([]byte{marker}, size), Samples: 1}))
+			for {
+				q, e := queueState(remote)
+				if q+int(e) > queued+int(evicted) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	assertQueue := func(track *Track, queued int, evicted uint64) {
+		q, e := queueState(track)
+		assert.Equal(t, queued, q)
+		assert.Equal(t, evicted, e)
+	}
+
+	// The video Track fills the remaining memory, and then evicts its own packets
+	write(localAudio, remoteAudio, 1, 2, 3, 4, 5)
+	write(localVideo, remoteVideo, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20)
+	assertQueue(remoteAudio, 5, 0)
+	assertQueue(remoteVideo, 5, 15)
+
+	// The audio Track has a higher priority, the video Track is evicted to make room
+	write(localAudio, remoteAudio, 6, 7)
+	assertQueue(remoteAudio, 7, 0)
+	assertQueue(remoteVideo, 3, 17)
+
+	write(localVideo, remoteVideo, 21)
+	assertQueue(remoteAudio, 7, 0)
+	assertQueue(remoteVideo, 3, 18)
+
+	// The oldest packets were evicted
+	for _, testCase := range []struct {
+		track   *Track
+		markers []byte
+	}{
+		{remoteAudio, []byte{1, 2, 3, 4, 5, 6, 7}},
+		{remoteVideo, []byte{19, 20, 21}},
+	} {
+		for _, marker := range testCase.markers {
+			p, err := testCase.track.ReadRTP()
+			assert.NoError(t, err)
+			assert.Equal(t, packetSize, p.MarshalSize())
+			assert.Equal(t, marker, p.Payload[len(p.Payload)-1])
+		}
+	}
+
+	api.receiveMemory.mu.Lock()
+	assert.Equal(t, 0, api.receiveMemory.used)
+	api.receiveMemory.mu.Unlock()
+
+	closePairNow(t, pcOfferAudio, pcAnswerAudio)
+	closePairNow(t, pcOfferVideo, pcAnswerVideo)
+}
+
+func TestTrackReplaySession(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	// Record 20ms Opus frames, sent every 40ms, along with traffic that isn't replayed
+	const packetInterval = 40 * time.Millisecond
+	capture := &bytes.Buffer{}
+	writer, err := rtpdump.NewWriter(capture, rtpdump.Header{Start: time.Now(), Source: net.IPv4(127, 0, 0, 1), Port: 5000})
+	assert.NoError(t, err)
+
+	writeRecorded := func(offset time.Duration, isRTCP bool, p rtp.Packet) {
+		payload, marshalErr := p.Marshal()
+		assert.NoError(t, marshalErr)
+		assert.NoError(t, writer.WritePacket(rtpdump.Packet{Offset: offset, IsRTCP: isRTCP, Payload: payload}))
+	}
+	for i := 0; i < 6; i++ {
+		writeRecorded(time.Duration(i)*packetInterval, false, rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 65533 + uint16(i), Timestamp: 1000 + uint32(i)*960, SSRC: 1234},
+			Payload: []byte{byte(i)},
+		})
+		writeRecorded(time.Duration(i)*packetInterval, false, rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(i), SSRC: 5678},
+			Payload: []byte{0xFF},
+		})
+	}
+	writeRecorded(packetInterval, true, rtp.Packet{})
+
+	local.mu.RLock()
+	firstSequenceNumber := local.sampleRewriter.nextSequenceNumber
+	firstTimestamp := local.sampleRewriter.nextTimestamp
+	local.mu.RUnlock()
+
+	replayErr := make(chan error)
+	go func() {
+		replayErr <- local.ReplaySession(capture)
+	}()
+
+	// Packets continue the stream of the Track, and arrive with the recorded timing
+	var firstArrival time.Time
+	for i := 0; i < 6; i++ {
+		p := readMediaRTP(t, remote)
+		if i == 0 {
+			firstArrival = time.Now()
+		}
+
+		assert.Equal(t, []byte{byte(i)}, p.Payload)
+		assert.Equal(t, local.SSRC(), p.SSRC)
+		assert.Equal(t, uint8(DefaultPayloadTypeOpus), p.PayloadType)
+		assert.Equal(t, firstSequenceNumber+uint16(i), p.SequenceNumber)
+		assert.Equal(t, firstTimestamp+uint32(i)*960, p.Timestamp)
+
+		drift := time.Since(firstArrival) - time.Duration(i)*packetInterval
+		assert.Less(t, int64(drift), int64(25*time.Millisecond))
+		assert.Greater(t, int64(drift), int64(-25*time.Millisecond))
+	}
+	assert.NoError(t, <-replayErr)
+
+	// Samples written after the replay continue from the last replayed packet
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x10}, Samples: 960}))
+	p := readMediaRTP(t, remote)
+	assert.Equal(t, []byte{0x10}, p.Payload)
+	assert.Equal(t, firstSequenceNumber+6, p.SequenceNumber)
+	assert.Equal(t, firstTimestamp+6*960, p.Timestamp)
+
+	assert.Error(t, local.ReplaySession(bytes.NewReader([]byte("not a recording"))))
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackReplaySession_Probing(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	// The replay lasts longer than a probe cluster
+	const packetCount = 6
+	capture := &bytes.Buffer{}
+	writer, err := rtpdump.NewWriter(capture, rtpdump.Header{Start: time.Now(), Source: net.IPv4(127, 0, 0, 1), Port: 5000})
+	assert.NoError(t, err)
+	for i := 0; i < packetCount; i++ {
+		payload, marshalErr := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(i), Timestamp: uint32(i) * 960, SSRC: 1234},
+			Payload: []byte{byte(i)},
+		}).Marshal()
+		assert.NoError(t, marshalErr)
+		assert.NoError(t, writer.WritePacket(rtpdump.Packet{Offset: time.Duration(i) * bandwidthProbeInterval, Payload: payload}))
+	}
+
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x10}, Samples: 960}))
+	lastSequenceNumber := readMediaRTP(t, remote).SequenceNumber
+	local.EnableBandwidthProbing(true)
+
+	replayErr := make(chan error)
+	go func() {
+		replayErr <- local.ReplaySession(capture)
+	}()
+
+	// Probes are sent before the replayed packets, and not in between them
+	for replayed := 0; replayed < packetCount; {
+		p := readMediaRTP(t, remote)
+		assert.Equal(t, lastSequenceNumber+1, p.SequenceNumber)
+		lastSequenceNumber = p.SequenceNumber
+
+		if !p.Padding {
+			assert.Equal(t, []byte{byte(replayed)}, p.Payload)
+			replayed++
+		} else {
+			assert.Zero(t, replayed, "probe sent during the replay")
+		}
+	}
+	assert.NoError(t, <-replayErr)
+
+	// Probing resumes with the next sample
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x11}, Samples: 960}))
+	for probes := 0; probes < 3; {
+		p := readMediaRTP(t, remote)
+		assert.Equal(t, lastSequenceNumber+1, p.SequenceNumber)
+		lastSequenceNumber = p.SequenceNumber
+		if p.Padding {
+			probes++
+		}
+	}
+
+	local.EnableBandwidthProbing(false)
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackSetCSRCMapping(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeOpus)
+
+	local.SetCSRCMapping(map[uint32]uint32{1111: 0xA, 2222: 0xB})
+
+	local.mu.RLock()
+	sequenceNumber := local.sampleRewriter.nextSequenceNumber
+	local.mu.RUnlock()
+
+	manyCSRC := []uint32{}
+	for i := uint32(0); i < 15; i++ {
+		manyCSRC = append(manyCSRC, 5000+i)
+	}
+
+	for _, testCase := range []struct {
+		name         string
+		ssrc         uint32
+		csrc         []uint32
+		expectedCSRC []uint32
+	}{
+		{"Forwarded", 1111, nil, []uint32{0xA}},
+		{"Forwarded With CSRC", 2222, []uint32{1111, 3333}, []uint32{0xB, 0xA, 3333}},
+		{"Forwarded Already Mapped", 2222, []uint32{0xB}, []uint32{0xB}},
+		{"Mixed", local.SSRC(), []uint32{1111, 2222, 3333}, []uint32{0xA, 0xB, 3333}},
+		{"Unmapped", local.SSRC(), []uint32{3333}, []uint32{3333}},
+		{"Too Many", 1111, manyCSRC, append([]uint32{0xA}, manyCSRC[:14]...)},
+		{"Too Many Already Mapped", 1111, append([]uint32{1111}, manyCSRC...), append([]uint32{0xA}, manyCSRC[:14]...)},
+	} {
+		p := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    DefaultPayloadTypeOpus,
+				SequenceNumber: sequenceNumber,
+				SSRC:           testCase.ssrc,
+				CSRC:           append([]uint32{}, testCase.csrc...),
+			},
+			Payload: []byte{0x01},
+		}
+		sequenceNumber++
+		assert.NoError(t, local.WriteRTP(p), testCase.name)
+
+		// The packet written isn't modified
+		assert.Equal(t, testCase.ssrc, p.SSRC, testCase.name)
+		assert.Equal(t, append([]uint32{}, testCase.csrc...), p.CSRC, testCase.name)
+
+		received := readMediaRTP(t, remote)
+		assert.Equal(t, local.SSRC(), received.SSRC, testCase.name)
+		assert.Equal(t, testCase.expectedCSRC, received.CSRC, testCase.name)
+	}
+
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackOnOneWayMedia(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, err := api.newPair(Configuration{})
+	require.NoError(t, err)
+
+	// Both sides send a Track on the same transceiver, and read what they receive
+	newTrack := func(pc *PeerConnection) *Track {
+		track, trackErr := pc.NewTrack(DefaultPayloadTypeOpus, randutil.NewMathRandomGenerator().Uint32(), "track", "pion")
+		require.NoError(t, trackErr)
+		_, trackErr = pc.AddTrack(track)
+		require.NoError(t, trackErr)
+		return track
+	}
+	offerLocal := newTrack(pcOffer)
+
+	remotes := make(chan *Track, 2)
+	onTrack := func(track *Track, _ *RTPReceiver) {
+		remotes <- track
+		go func() {
+			for {
+				if _, readErr := track.ReadRTP(); readErr != nil {
+					return
+				}
+			}
+		}()
+	}
+	pcOffer.OnTrack(onTrack)
+	pcAnswer.OnTrack(onTrack)
+
+	offer, err := pcOffer.CreateOffer(nil)
+	require.NoError(t, err)
+	offerGatheringComplete := GatheringCompletePromise(pcOffer)
+	require.NoError(t, pcOffer.SetLocalDescription(offer))
+	<-offerGatheringComplete
+	require.NoError(t, pcAnswer.SetRemoteDescription(*pcOffer.LocalDescription()))
+
+	answerLocal := newTrack(pcAnswer)
+	answer, err := pcAnswer.CreateAnswer(nil)
+	require.NoError(t, err)
+	answerGatheringComplete := GatheringCompletePromise(pcAnswer)
+	require.NoError(t, pcAnswer.SetLocalDescription(answer))
+	<-answerGatheringComplete
+	require.NoError(t, pcOffer.SetRemoteDescription(*pcAnswer.LocalDescription()))
+
+	var answerSending atomicBool
+	answerSending.set(true)
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			_ = offerLocal.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 960})
+			if answerSending.get() {
+				_ = answerLocal.WriteSample(media.Sample{Data: []byte{0x02}, Samples: 960})
+			}
+		}
+	}()
+	<-remotes
+	<-remotes
+
+	const threshold = 200 * time.Millisecond
+	offerLocal.SetOneWayMediaThreshold(threshold)
+
+	oneWay := make(chan struct{}, 1)
+	offerLocal.OnOneWayMedia(func() {
+		oneWay <- struct{}{}
+	})
+
+	// Media flowing in both directions isn't reported
+	select {
+	case <-oneWay:
+		t.Fatal("OnOneWayMedia fired while media flows in both directions")
+	case <-time.After(3 * threshold):
+	}
+
+	// Still sending, but nothing is received any more
+	answerSending.set(false)
+	select {
+	case <-oneWay:
+	case <-time.After(10 * threshold):
+		t.Fatal("OnOneWayMedia didn't fire for one-way media")
+	}
+
+	close(done)
+	<-writerDone
+	closePairNow(t, pcOffer, pcAnswer)
+}
+
+func TestTrackOnOneWayMedia_Unset(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// A Track that is never sent stops being monitored once the handler is unset.
+	// Thresholds too short to be checked four times are checked every millisecond
+	for _, threshold := range []time.Duration{20 * time.Millisecond, 3 * time.Nanosecond} {
+		track, err := NewTrack(DefaultPayloadTypeOpus, 5000, "audio", "pion", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+		require.NoError(t, err)
+
+		track.SetOneWayMediaThreshold(threshold)
+		track.OnOneWayMedia(func() {
+			t.Error("OnOneWayMedia fired for a Track that isn't sent")
+		})
+		track.OnOneWayMedia(nil)
+
+		for started := true; started; {
+			time.Sleep(oneWayMediaMinCheckInterval)
+
+			track.mu.RLock()
+			started = track.oneWayMediaStarted
+			track.mu.RUnlock()
+		}
+	}
+}
+
+func TestTrackSetSpatialLayerSSRCs(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const samplesPerLayer = 10
+
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	require.NoError(t, err)
+
+	// The RTP header isn't encrypted by SRTP, so the sequence numbers of each SSRC can be observed
+	var sentLock sync.Mutex
+	sent := map[uint32][]uint16{}
+	wan.AddChunkFilter(func(c vnet.Chunk) bool {
+		b := c.UserData()
+		if len(b) < rtpHeaderSize || b[0] < 128 || b[0] > 191 || (b[1] >= 192 && b[1] <= 223) {
+			return true
+		}
+
+		header := &rtp.Header{}
+		if header.Unmarshal(b) == nil {
+			sentLock.Lock()
+			sent[header.SSRC] = append(sent[header.SSRC], header.SequenceNumber)
+			sentLock.Unlock()
+		}
+		return true
+	})
+
+	newAPI := func(ip string) *API {
+		n := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+		require.NoError(t, wan.AddNet(n))
+
+		// SRTCP isn't authenticated reliably with AEAD_AES_128_GCM
+		s := SettingEngine{}
+		s.SetVNet(n)
+		s.SetSRTPProtectionProfiles([]SRTPProtectionProfile{SRTPProtectionProfileAes128CmHmacSha1_80})
+		api := NewAPI(WithSettingEngine(s))
+		api.mediaEngine.RegisterDefaultCodecs()
+		return api
+	}
+	offerAPI, answerAPI := newAPI("1.2.3.4"), newAPI("1.2.3.5")
+	require.NoError(t, wan.Start())
+
+	pcOffer, err := offerAPI.NewPeerConnection(Configuration{})
+	require.NoError(t, err)
+	pcAnswer, err := answerAPI.NewPeerConnection(Configuration{})
+	require.NoError(t, err)
+
+	track, err := pcOffer.NewTrack(DefaultPayloadTypeVP8, 1000, "video", "pion")
+	require.NoError(t, err)
+	assert.Equal(t, errTrackSpatialLayerSSRCInvalid, track.SetSpatialLayerSSRCs([]uint32{2000, 1000}))
+	assert.Equal(t, errTrackSpatialLayerSSRCInvalid, track.SetSpatialLayerSSRCs([]uint32{2000, 0}))
+	require.NoError(t, track.SetSpatialLayerSSRCs([]uint32{2000, 3000}))
+	_, err = pcOffer.AddTrack(track)
+	require.NoError(t, err)
+
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	pcOffer.OnConnectionStateChange(func(state PeerConnectionState) {
+		if state == PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(connected) })
+		}
+	})
+
+	require.NoError(t, signalPair(pcOffer, pcAnswer))
+	assert.Contains(t, pcOffer.LocalDescription().SDP, "a=ssrc-group:SIM 1000 2000 3000\r\n")
+	<-connected
+
+	assert.Equal(t, errTrackSpatialLayerUnknown, track.WriteSample(media.Sample{Data: []byte{0x00}, Samples: 3000, SpatialLayer: 3}))
+
+	// Write until each layer has been sent enough samples
+	layerSSRCs := []uint32{1000, 2000, 3000}
+	for {
+		sentLock.Lock()
+		done := true
+		for _, ssrc := range layerSSRCs {
+			done = done && len(sent[ssrc]) >= samplesPerLayer
+		}
+		sentLock.Unlock()
+		if done {
+			break
+		}
+
+		for layer := range layerSSRCs {
+			assert.NoError(t, track.WriteSample(media.Sample{Data: []byte{0x00, byte(layer)}, Samples: 3000, SpatialLayer: uint8(layer)}))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Only the layer SSRCs are sent, each with its own continuous sequence numbers
+	sentLock.Lock()
+	assert.Len(t, sent, len(layerSSRCs))
+	for _, ssrc := range layerSSRCs {
+		sequenceNumbers := sent[ssrc]
+		for i := 1; i < len(sequenceNumbers); i++ {
+			assert.Equal(t, sequenceNumbers[i-1]+1, sequenceNumbers[i], "SSRC %d", ssrc)
+		}
+	}
+	sentLock.Unlock()
+
+	// RTCP sent for a layer SSRC is read from the RTPSender, and REMB for a
+	// layer applies to the Track
+	require.NoError(t, pcAnswer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 2000}}))
+	require.NoError(t, pcAnswer.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 300000, SSRCs: []uint32{3000}}}))
+	var pliSSRCs []uint32
+	for i := 0; i < 2; i++ {
+		pkts, readErr := pcOffer.GetSenders()[0].ReadRTCP()
+		require.NoError(t, readErr)
+		for _, pkt := range pkts {
+			if pli, ok := pkt.(*rtcp.PictureLossIndication); ok {
+				pliSSRCs = append(pliSSRCs, pli.MediaSSRC)
+			}
+		}
+	}
+	assert.Equal(t, []uint32{2000}, pliSSRCs)
+	assert.Equal(t, 300000, track.BandwidthEstimate())
+
+	closePairNow(t, pcOffer, pcAnswer)
+	assert.NoError(t, wan.Stop())
+}
+
+func TestTrackPacketizationLatency(t *testing.T) {
+	t.Run("Moving Average", func(t *testing.T) {
+		track := &Track{}
+		assert.Zero(t, track.PacketizationLatency())
+
+		track.updatePacketizationLatency(time.Now().Add(-8 * time.Millisecond))
+		assert.InDelta(t, int64(8*time.Millisecond), int64(track.PacketizationLatency()), float64(time.Millisecond))
+
+		track.updatePacketizationLatency(time.Now().Add(-16 * time.Millisecond))
+		assert.InDelta(t, int64(9*time.Millisecond), int64(track.PacketizationLatency()), float64(time.Millisecond))
+	})
+
+	t.Run("WriteSample", func(t *testing.T) {
+		lim := test.TimeOut(time.Second * 30)
+		defer lim.Stop()
+
+		report := test.CheckRoutines(t)
+		defer report()
+
+		api := NewAPI()
+		api.mediaEngine.RegisterDefaultCodecs()
+		pcOffer, pcAnswer, err := api.newPair(Configuration{})
+		require.NoError(t, err)
+
+		track, err := pcOffer.NewTrack(DefaultPayloadTypeOpus, randutil.NewMathRandomGenerator().Uint32(), "audio", "pion")
+		require.NoError(t, err)
+		_, err = pcOffer.AddTrack(track)
+		require.NoError(t, err)
+		assert.Zero(t, track.PacketizationLatency())
+
+		// Empty samples aren't accounted
+		assert.NoError(t, track.WriteSample(media.Sample{Samples: 960}))
+		assert.Zero(t, track.PacketizationLatency())
+
+		require.NoError(t, signalPair(pcOffer, pcAnswer))
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, track.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 960}))
+		}
+		assert.NotZero(t, track.PacketizationLatency())
+		assert.Less(t, int64(track.PacketizationLatency()), int64(time.Second))
+
+		closePairNow(t, pcOffer, pcAnswer)
+	})
+}
@@ -0,0 +1,86 @@
+// +build !js
+
+package webrtc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrackGroup is a set of related remote Tracks, for example the audio, video
+// and screenshare of one participant in a conference, whose bitrates are
+// accounted together. A Track only measures its bitrate while it belongs to
+// at least one TrackGroup, packets received before it was added don't count.
+type TrackGroup struct {
+	mu sync.RWMutex
+
+	tracks  []*Track
+	timegen func() time.Time
+}
+
+// NewTrackGroup creates a TrackGroup of tracks
+func NewTrackGroup(tracks ...*Track) *TrackGroup {
+	for _, t := range tracks {
+		atomic.AddInt32(&t.bitrateGroups, 1)
+	}
+	return &TrackGroup{tracks: append([]*Track{}, tracks...)}
+}
+
+// Add adds a Track to the group, it is a no-op if the Track is already a member
+func (g *TrackGroup) Add(track *Track) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, t := range g.tracks {
+		if t == track {
+			return
+		}
+	}
+	g.tracks = append(g.tracks, track)
+	atomic.AddInt32(&track.bitrateGroups, 1)
+}
+
+// Remove removes a Track from the group
+func (g *TrackGroup) Remove(track *Track) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, t := range g.tracks {
+		if t == track {
+			g.tracks = append(g.tracks[:i], g.tracks[i+1:]...)
+			atomic.AddInt32(&track.bitrateGroups, -1)
+			return
+		}
+	}
+}
+
+// Tracks returns the members of the group
+func (g *TrackGroup) Tracks() []*Track {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Track{}, g.tracks...)
+}
+
+// TotalBitrate returns the sum of the Bitrate of the members of the group, in
+// bits per second. The bitrates of all members are measured over the same
+// window, so the sum doesn't depend on when each Track last received a packet.
+//
+// Packets a member receives are only measured once they are read from the SRTP
+// session. For accounting that doesn't depend on the application reading every
+// Track, set SettingEngine.SetReceiveMemoryLimit so all remote Tracks read ahead.
+func (g *TrackGroup) TotalBitrate() float64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	now := time.Now()
+	if g.timegen != nil {
+		now = g.timegen()
+	}
+
+	total := 0.0
+	for _, t := range g.tracks {
+		total += t.bitrateAt(now)
+	}
+	return total
+}
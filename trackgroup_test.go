@@ -0,0 +1,119 @@
+// +build !js
+
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/transport/test"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackGroupTotalBitrate(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+
+	now := time.Now().Add(time.Hour)
+	timegen := func() time.Time { return now }
+
+	group := NewTrackGroup()
+	group.timegen = timegen
+
+	expected := 0.0
+	for _, member := range []struct {
+		payloadType uint8
+		size        int
+	}{
+		{DefaultPayloadTypeOpus, 100},
+		{DefaultPayloadTypeVP8, 1000},
+		{DefaultPayloadTypeVP8, 500},
+	} {
+		pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, member.payloadType)
+		defer closePairNow(t, pcOffer, pcAnswer)
+
+		// Drain the warmup packets, they were received before the window that is measured
+		assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 1}))
+		readMediaRTP(t, remote)
+
+		remote.mu.Lock()
+		remote.timegen = timegen
+		remote.mu.Unlock()
+
+		// Packets are only measured once the Track belongs to a group
+		assert.NoError(t, local.WriteSample(media.Sample{Data: make([]byte, member.size), Samples: 1}))
+		readMediaRTP(t, remote)
+		assert.Equal(t, 0.0, remote.Bitrate())
+		group.Add(remote)
+
+		bytes := 0
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, local.WriteSample(media.Sample{Data: make([]byte, member.size), Samples: 1}))
+			bytes += readMediaRTP(t, remote).MarshalSize()
+		}
+		assert.Equal(t, float64(bytes*8), remote.Bitrate())
+		expected += float64(bytes * 8)
+	}
+
+	assert.Len(t, group.Tracks(), 3)
+	assert.Equal(t, expected, group.TotalBitrate())
+
+	// Removed members no longer count
+	removed := group.Tracks()[2]
+	group.Remove(removed)
+	assert.Equal(t, expected-removed.Bitrate(), group.TotalBitrate())
+
+	// Packets received before the window no longer count
+	now = now.Add(trackBitrateWindow)
+	assert.Equal(t, 0.0, group.TotalBitrate())
+}
+
+func TestTrackGroupTotalBitrate_Unread(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	api := NewAPI()
+	api.mediaEngine.RegisterDefaultCodecs()
+	pcOffer, pcAnswer, local, remote := newLoopbackTrack(t, api, DefaultPayloadTypeVP8)
+
+	assert.NoError(t, local.WriteSample(media.Sample{Data: []byte{0x01}, Samples: 1}))
+	readMediaRTP(t, remote)
+
+	now := time.Now().Add(time.Hour)
+	timegen := func() time.Time { return now }
+	remote.mu.Lock()
+	remote.timegen = timegen
+	remote.mu.Unlock()
+
+	// Packets read ahead are measured before the application reads them
+	group := NewTrackGroup(remote)
+	group.timegen = timegen
+	remote.SetMaxLatency(time.Minute)
+	const size = 1000
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, local.WriteSample(media.Sample{Data: make([]byte, size), Samples: 1}))
+	}
+	for group.TotalBitrate() < 5*size*8 {
+		time.Sleep(time.Millisecond)
+	}
+	measured := group.TotalBitrate()
+
+	// Reading them doesn't measure them again
+	for i := 0; i < 5; i++ {
+		readMediaRTP(t, remote)
+	}
+	assert.Equal(t, measured, group.TotalBitrate())
+
+	remote.SetMaxLatency(0)
+	closePairNow(t, pcOffer, pcAnswer)
+}
@@ -0,0 +1,302 @@
+// +build !js
+
+package webrtc
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+)
+
+const (
+	trackReceiveStateVersion    = 1
+	trackReceiveStateHeaderSize = 26
+	trackReceiveStateFlagActive = 1 << 0
+)
+
+// TrackReceiveState is a snapshot of the receive side of a remote Track. It
+// allows another instance (for example a SFU taking over the session) to resume
+// forwarding the stream where this one stopped.
+//
+// A TrackReceiveState is serialized with Marshal as the following big-endian layout
+//
+//   0                   1                   2                   3
+//   0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |    Version    |     Flags     |  PayloadType  |   Reserved    |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                             SSRC                              |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                         RolloverCount                         |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                         TimestampBase                         |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                         LastTimestamp                         |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |      LastSequenceNumber       |     SRTPProtectionProfile     |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |       Buffered Packets        |       Packet 1 Length         |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |                           Packet 1                            |
+//  |                             ....                              |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//  |       Packet 2 Length         |           Packet 2            |
+//  |                             ....                              |
+//  +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// Version is currently 1. Bit 0 of Flags is set if any packet has been read
+// from the Track, otherwise the sequence and timestamp fields are zero.
+type TrackReceiveState struct {
+	// Active is true if any packet has been read from the Track
+	Active bool
+
+	SSRC        uint32
+	PayloadType uint8
+
+	// LastSequenceNumber is the highest sequence number read, and RolloverCount
+	// the amount of times the sequence number has wrapped
+	LastSequenceNumber uint16
+	RolloverCount      uint32
+
+	// TimestampBase is the RTP timestamp of the first packet read, and
+	// LastTimestamp the one of the packet with LastSequenceNumber
+	TimestampBase uint32
+	LastTimestamp uint32
+
+	// SRTPProtectionProfile is the profile negotiated for the SRTP context the
+	// stream was received on, as assigned in RFC 5764 Section 4.1.2. The
+	// keying material is not part of the state.
+	SRTPProtectionProfile uint16
+
+	// BufferedPackets are the raw RTP packets that were received, but not yet read
+	BufferedPackets [][]byte
+}
+
+// Marshal serializes the TrackReceiveState
+func (s TrackReceiveState) Marshal() ([]byte, error) {
+	size := trackReceiveStateHeaderSize
+	for _, p := range s.BufferedPackets {
+		if len(p) > 0xFFFF {
+			return nil, errTrackReceiveStatePacketTooLarge
+		}
+		size += 2 + len(p)
+	}
+	if len(s.BufferedPackets) > 0xFFFF {
+		return nil, errTrackReceiveStateTooManyPackets
+	}
+
+	b := make([]byte, size)
+	b[0] = trackReceiveStateVersion
+	if s.Active {
+		b[1] |= trackReceiveStateFlagActive
+	}
+	b[2] = s.PayloadType
+	binary.BigEndian.PutUint32(b[4:], s.SSRC)
+	binary.BigEndian.PutUint32(b[8:], s.RolloverCount)
+	binary.BigEndian.PutUint32(b[12:], s.TimestampBase)
+	binary.BigEndian.PutUint32(b[16:], s.LastTimestamp)
+	binary.BigEndian.PutUint16(b[20:], s.LastSequenceNumber)
+	binary.BigEndian.PutUint16(b[22:], s.SRTPProtectionProfile)
+	binary.BigEndian.PutUint16(b[24:], uint16(len(s.BufferedPackets)))
+
+	offset := trackReceiveStateHeaderSize
+	for _, p := range s.BufferedPackets {
+		binary.BigEndian.PutUint16(b[offset:], uint16(len(p)))
+		offset += 2
+		offset += copy(b[offset:], p)
+	}
+
+	return b, nil
+}
+
+// Unmarshal parses a TrackReceiveState serialized by Marshal
+func (s *TrackReceiveState) Unmarshal(b []byte) error {
+	if len(b) < trackReceiveStateHeaderSize {
+		return errTrackReceiveStateTooShort
+	} else if b[0] != trackReceiveStateVersion {
+		return errTrackReceiveStateVersion
+	}
+
+	s.Active = b[1]&trackReceiveStateFlagActive != 0
+	s.PayloadType = b[2]
+	s.SSRC = binary.BigEndian.Uint32(b[4:])
+	s.RolloverCount = binary.BigEndian.Uint32(b[8:])
+	s.TimestampBase = binary.BigEndian.Uint32(b[12:])
+	s.LastTimestamp = binary.BigEndian.Uint32(b[16:])
+	s.LastSequenceNumber = binary.BigEndian.Uint16(b[20:])
+	s.SRTPProtectionProfile = binary.BigEndian.Uint16(b[22:])
+
+	packetCount := int(binary.BigEndian.Uint16(b[24:]))
+	s.BufferedPackets = make([][]byte, 0, packetCount)
+
+	offset := trackReceiveStateHeaderSize
+	for i := 0; i < packetCount; i++ {
+		if len(b) < offset+2 {
+			return errTrackReceiveStateTooShort
+		}
+		length := int(binary.BigEndian.Uint16(b[offset:]))
+		offset += 2
+
+		if len(b) < offset+length {
+			return errTrackReceiveStateTooShort
+		}
+		s.BufferedPackets = append(s.BufferedPackets, append([]byte{}, b[offset:offset+length]...))
+		offset += length
+	}
+
+	return nil
+}
+
+// receiveState tracks the position of a remote Track in its RTP stream
+type receiveState struct {
+	active             bool
+	lastSequenceNumber uint16
+	rolloverCount      uint32
+	timestampBase      uint32
+	lastTimestamp      uint32
+
+	// resuming is true after a state has been imported, until a packet newer
+	// than it is received by this Track. pendingImported counts the imported
+	// packets not read yet, they are read before the ones of this Track
+	resuming        bool
+	pendingImported int
+}
+
+// update accounts a RTP packet that has been read from the Track. It returns
+// false if the packet must be dropped, because the Track is resuming and the
+// instance the state was imported from already delivered it.
+func (s *receiveState) update(b []byte) bool {
+	imported := s.pendingImported != 0
+	if imported {
+		s.pendingImported--
+	}
+
+	if len(b) < rtpHeaderSize {
+		return true
+	}
+	sequenceNumber := binary.BigEndian.Uint16(b[2:])
+	timestamp := binary.BigEndian.Uint32(b[4:])
+
+	if !s.active {
+		s.active = true
+		s.lastSequenceNumber = sequenceNumber
+		s.timestampBase = timestamp
+		s.lastTimestamp = timestamp
+		return true
+	}
+
+	// Ignore duplicate and reordered packets. Imported ones weren't delivered
+	// by the exporting instance, whatever their order
+	if diff := sequenceNumber - s.lastSequenceNumber; diff == 0 || diff >= 0x8000 {
+		return imported || !s.resuming
+	}
+
+	if !imported {
+		s.resuming = false
+	}
+	if sequenceNumber < s.lastSequenceNumber {
+		s.rolloverCount++
+	}
+	s.lastSequenceNumber = sequenceNumber
+	s.lastTimestamp = timestamp
+	return true
+}
+
+// ExportReceiveState freezes the receive side of a remote Track into a
+// TrackReceiveState. Packets that were received but not yet read are moved
+// into the state, so they are delivered exactly once by whoever imports it.
+// These are the packets peeked while the Track was negotiated, and the ones
+// read ahead if the Track reads ahead, see SetMaxLatency and
+// SettingEngine.SetReceiveMemoryLimit.
+//
+// Packets that are still held by the SRTP ReadStream of the Track can't be
+// taken from it without blocking, and are not part of the state. Reading ahead
+// pulls packets from the ReadStream as soon as they are received, so that the
+// state includes all of them.
+//
+// The Track stops delivering packets once exported, so none is delivered by both
+// this Track and the importer. Read returns ErrTrackReceiveStateExported, packets
+// that are received later are dropped and reading ahead stops.
+func (t *Track) ExportReceiveState() (TrackReceiveState, error) {
+	t.mu.Lock()
+	r, q := t.receiver, t.readAhead
+	if r != nil {
+		atomic.StoreInt32(&t.receiveStateExported, 1)
+	}
+	t.mu.Unlock()
+
+	if r == nil {
+		return TrackReceiveState{}, errTrackLocalTrackReceiveState
+	}
+
+	// Packets read ahead are older than the ones left buffered, the
+	// readAheadLoop reads the buffered packets first. It no longer queues
+	// packets once the Track is exported
+	var buffered [][]byte
+	if q != nil {
+		q.mu.Lock()
+		buffered = q.packets
+		q.packets, q.arrivals = nil, nil
+		q.err = ErrTrackReceiveStateExported
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		if q.memory != nil {
+			released := 0
+			for _, p := range buffered {
+				released += len(p)
+			}
+			q.memory.release(q, released)
+		}
+	}
+
+	state := TrackReceiveState{}
+	if transport := r.Transport(); transport != nil {
+		transport.lock.RLock()
+		state.SRTPProtectionProfile = uint16(transport.srtpProtectionProfile)
+		transport.lock.RUnlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state.Active = t.receiveState.active
+	state.SSRC = t.ssrc
+	state.PayloadType = t.payloadType
+	state.LastSequenceNumber = t.receiveState.lastSequenceNumber
+	state.RolloverCount = t.receiveState.rolloverCount
+	state.TimestampBase = t.receiveState.timestampBase
+	state.LastTimestamp = t.receiveState.lastTimestamp
+	state.BufferedPackets = append(buffered, t.buffered...)
+	t.buffered = nil
+
+	return state, nil
+}
+
+// ImportReceiveState resumes a remote Track from a TrackReceiveState exported
+// by ExportReceiveState. The buffered packets of the state are returned by Read
+// before any packets received by this Track. Until this Track receives a packet
+// newer than the ones of the state, Read drops the packets that are not, as the
+// exporting instance already delivered them. The sequence number rollovers and
+// timestamp base continue from the state. The SSRC and PayloadType of the Track
+// are not modified.
+func (t *Track) ImportReceiveState(state TrackReceiveState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.receiver == nil {
+		return errTrackLocalTrackReceiveState
+	}
+
+	t.receiveState = receiveState{
+		active:             state.Active,
+		lastSequenceNumber: state.LastSequenceNumber,
+		rolloverCount:      state.RolloverCount,
+		timestampBase:      state.TimestampBase,
+		lastTimestamp:      state.LastTimestamp,
+		resuming:           state.Active,
+		pendingImported:    len(state.BufferedPackets),
+	}
+	t.buffered = append(append([][]byte{}, state.BufferedPackets...), t.buffered...)
+
+	return nil
+}
@@ -0,0 +1,166 @@
+// +build !js
+
+package webrtc
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func marshalTestRTP(t *testing.T, sequenceNumber uint16, timestamp uint32, payload []byte) []byte {
+	b, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: sequenceNumber,
+			Timestamp:      timestamp,
+			SSRC:           5000,
+		},
+		Payload: payload,
+	}).Marshal()
+	require.NoError(t, err)
+	return b
+}
+
+func TestTrackReceiveState_RoundTrip(t *testing.T) {
+	source := &Track{receiver: &RTPReceiver{}, ssrc: 5000, payloadType: 96}
+
+	// Read across a sequence number wrap, with a reordered packet
+	for _, p := range [][]byte{
+		marshalTestRTP(t, 65534, 1000, []byte{0x01}),
+		marshalTestRTP(t, 65535, 1090, []byte{0x02}),
+		marshalTestRTP(t, 1, 1270, []byte{0x03}),
+		marshalTestRTP(t, 0, 1180, []byte{0x04}),
+	} {
+		source.buffered = append(source.buffered, p)
+		_, err := source.Read(make([]byte, receiveMTU))
+		assert.NoError(t, err)
+	}
+
+	// Packets that are received but not read yet
+	// Packets that are received but not read yet, including one that is older
+	// than the last packet read
+	unread := [][]byte{
+		marshalTestRTP(t, 3, 1450, []byte{0x06}),
+		marshalTestRTP(t, 0, 1180, []byte{0x04}),
+		marshalTestRTP(t, 2, 1360, []byte{0x05}),
+	}
+	source.buffered = append(source.buffered, unread...)
+
+	exported, err := source.ExportReceiveState()
+	assert.NoError(t, err)
+	assert.Equal(t, TrackReceiveState{
+		Active:             true,
+		SSRC:               5000,
+		PayloadType:        96,
+		LastSequenceNumber: 1,
+		RolloverCount:      1,
+		TimestampBase:      1000,
+		LastTimestamp:      1270,
+		BufferedPackets:    unread,
+	}, exported)
+	assert.Empty(t, source.buffered)
+
+	// The source no longer delivers packets, so none is delivered twice
+	source.buffered = append(source.buffered, marshalTestRTP(t, 4, 1540, []byte{0x07}))
+	_, err = source.Read(make([]byte, receiveMTU))
+	assert.True(t, errors.Is(err, ErrTrackReceiveStateExported))
+
+	// Move the state to another instance
+	marshaled, err := exported.Marshal()
+	assert.NoError(t, err)
+
+	writer, reader := net.Pipe()
+	go func() {
+		_, writeErr := writer.Write(marshaled)
+		assert.NoError(t, writeErr)
+		assert.NoError(t, writer.Close())
+	}()
+	received, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+
+	imported := TrackReceiveState{}
+	assert.NoError(t, imported.Unmarshal(received))
+	assert.Equal(t, exported, imported)
+
+	destination := &Track{receiver: &RTPReceiver{}}
+	assert.NoError(t, destination.ImportReceiveState(imported))
+
+	for _, expected := range unread {
+		b := make([]byte, receiveMTU)
+		n, readErr := destination.Read(b)
+		assert.NoError(t, readErr)
+		assert.Equal(t, expected, b[:n])
+	}
+
+	// Packets the source already delivered are dropped, until a newer one is
+	// received. Reordered packets are returned again after that
+	fresh := marshalTestRTP(t, 4, 1540, []byte{0x07})
+	reordered := marshalTestRTP(t, 3, 1450, []byte{0x06})
+	destination.buffered = append(destination.buffered,
+		marshalTestRTP(t, 3, 1450, []byte{0x06}),
+		marshalTestRTP(t, 65535, 1090, []byte{0x02}),
+		fresh,
+		reordered,
+	)
+	for _, expected := range [][]byte{fresh, reordered} {
+		b := make([]byte, receiveMTU)
+		n, readErr := destination.Read(b)
+		assert.NoError(t, readErr)
+		assert.Equal(t, expected, b[:n])
+	}
+
+	resumed, err := destination.ExportReceiveState()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(4), resumed.LastSequenceNumber)
+	assert.Equal(t, uint32(1), resumed.RolloverCount)
+	assert.Equal(t, uint32(1000), resumed.TimestampBase)
+	assert.Equal(t, uint32(1540), resumed.LastTimestamp)
+}
+
+func TestTrackReceiveState_ReadAhead(t *testing.T) {
+	readAhead := []byte{0x00, 0x01}
+	peeked := []byte{0x02, 0x03}
+
+	queue := &readAheadQueue{packets: [][]byte{readAhead}, arrivals: []time.Time{time.Now()}}
+	queue.cond = sync.NewCond(&queue.mu)
+	source := &Track{receiver: &RTPReceiver{}, readAhead: queue, buffered: [][]byte{peeked}}
+
+	exported, err := source.ExportReceiveState()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{readAhead, peeked}, exported.BufferedPackets)
+	assert.Empty(t, queue.packets)
+	assert.Empty(t, queue.arrivals)
+	assert.Empty(t, source.buffered)
+
+	// Readers waiting on the queue return once it is exported
+	_, err = source.Read(make([]byte, receiveMTU))
+	assert.True(t, errors.Is(err, ErrTrackReceiveStateExported))
+}
+
+func TestTrackReceiveState_Errors(t *testing.T) {
+	local, err := NewTrack(DefaultPayloadTypeOpus, 5000, "audio", "pion", NewRTPOpusCodec(DefaultPayloadTypeOpus, 48000))
+	assert.NoError(t, err)
+
+	_, err = local.ExportReceiveState()
+	assert.Equal(t, errTrackLocalTrackReceiveState, err)
+	assert.Equal(t, errTrackLocalTrackReceiveState, local.ImportReceiveState(TrackReceiveState{}))
+
+	state := TrackReceiveState{BufferedPackets: [][]byte{{0x00, 0x01}}}
+	marshaled, err := state.Marshal()
+	assert.NoError(t, err)
+
+	assert.Equal(t, errTrackReceiveStateTooShort, (&TrackReceiveState{}).Unmarshal(marshaled[:len(marshaled)-1]))
+	assert.Equal(t, errTrackReceiveStateTooShort, (&TrackReceiveState{}).Unmarshal(marshaled[:trackReceiveStateHeaderSize-1]))
+
+	marshaled[0] = 2
+	assert.Equal(t, errTrackReceiveStateVersion, (&TrackReceiveState{}).Unmarshal(marshaled))
+}